@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// accessLogger copies the access log of a mounted volume with
+// `access-log=true` into LOG_DIR/<volume>.access.log, which rotates like
+// the driver log. The client serves the log at <mountpoint>/.accesslog
+// only while it is read.
+type accessLogger struct {
+	f      *os.File
+	done   chan struct{}
+	exited chan struct{}
+}
+
+// accessLogEnabled reports whether the volume records its access log.
+func accessLogEnabled(v *jfsVolume) bool {
+	val, ok := v.Options["access-log"]
+	return ok && isFlagEnabled(val)
+}
+
+// accessLogPath is the access log file of a volume.
+func accessLogPath(v *jfsVolume) string {
+	return filepath.Join(logDir(), dockerName(v)+".access.log")
+}
+
+// startAccessLog starts recording the access log of a freshly mounted
+// volume. The caller holds v.mu.
+func startAccessLog(v *jfsVolume) {
+	if !accessLogEnabled(v) || v.accessLog != nil {
+		return
+	}
+	log := mountLog.WithField("volume", dockerName(v))
+	out, err := openRotatingFile(accessLogPath(v), logRetentionFromEnv())
+	if err != nil {
+		log.Warnf("cannot record the access log: %s", err)
+		return
+	}
+	f, err := os.Open(filepath.Join(v.Mountpoint, ".accesslog"))
+	if err != nil {
+		out.Close()
+		log.Warnf("cannot record the access log: %s", err)
+		return
+	}
+	a := &accessLogger{f: f, done: make(chan struct{}), exited: make(chan struct{})}
+	v.accessLog = a
+	go func() {
+		defer close(a.exited)
+		defer out.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			select {
+			case <-a.done:
+				return
+			default:
+			}
+			line := scanner.Text()
+			// The client writes "#" while idle to keep readers alive.
+			if line == "#" || line == "" {
+				continue
+			}
+			out.Write([]byte(sanitizeAccessLogLine(line) + "\n"))
+		}
+	}()
+}
+
+// stopAccessLog stops recording the access log so the open log does not
+// keep the mount busy. A pending read returns within a few seconds. The
+// caller holds v.mu.
+func stopAccessLog(v *jfsVolume) {
+	a := v.accessLog
+	if a == nil {
+		return
+	}
+	v.accessLog = nil
+	close(a.done)
+	a.f.Close()
+	select {
+	case <-a.exited:
+	case <-time.After(5 * time.Second):
+		mountLog.WithField("volume", dockerName(v)).Warn("access log reader did not stop")
+	}
+}
+
+// sanitizeAccessLogLine replaces control characters, which file names may
+// contain, so every entry stays on one line.
+func sanitizeAccessLogLine(line string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' {
+			return '?'
+		}
+		return r
+	}, line)
+}
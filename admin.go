@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// defaultAdminSocket is where the admin API listens unless ADMIN_SOCKET
+// overrides it. The admin API carries operational actions that are not part
+// of the Docker volume plugin protocol.
+const defaultAdminSocket = "/run/docker/plugins/jfs-admin.sock"
+
+type adminServer struct {
+	d   *jfsDriver
+	mux *http.ServeMux
+}
+
+func newAdminServer(d *jfsDriver) *adminServer {
+	a := &adminServer{d: d, mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /volumes", a.listVolumes)
+	a.mux.HandleFunc("GET /volumes/{name}", a.inspectVolume)
+	a.mux.HandleFunc("POST /volumes/{name}/unmount", a.unmount)
+	a.mux.HandleFunc("POST /volumes/{name}/remount", a.remount)
+	for _, op := range []string{"gc", "fsck", "warmup"} {
+		a.mux.HandleFunc("POST /volumes/{name}/"+op, a.maintain(op))
+	}
+	a.mux.HandleFunc("POST /volumes/{name}/rotate-credentials", a.rotateCredentials)
+	a.mux.HandleFunc("POST /volumes/{name}/reset-quarantine", a.resetQuarantine)
+	a.mux.HandleFunc("GET /volumes/{name}/history", a.history)
+	a.mux.HandleFunc("GET /volumes/{name}/events", a.events)
+	a.mux.HandleFunc("GET /volumes/{name}/sessions", a.sessions)
+	a.mux.HandleFunc("POST /volumes/{name}/sessions/cleanup", a.cleanupSessions)
+	a.mux.HandleFunc("POST /volumes/{name}/profile", a.profile)
+	a.mux.HandleFunc("POST /volumes/{name}/bench", a.bench)
+	a.mux.HandleFunc("GET /orphans", a.orphans)
+	a.mux.HandleFunc("POST /orphans/check", a.checkOrphans)
+	a.mux.HandleFunc("GET /diagnostics", a.diagnostics)
+	a.mux.HandleFunc("GET /report", a.report)
+	a.mux.HandleFunc("GET /state", a.exportState)
+	a.mux.HandleFunc("POST /state", a.importState)
+	a.mux.Handle("GET /metrics", promhttp.Handler())
+	a.mux.HandleFunc("GET /healthz", d.healthz)
+	a.mux.HandleFunc("GET /readyz", readyz)
+	a.mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, getBuildInfo())
+	})
+	return a
+}
+
+// serveUnix listens on the given unix socket and serves the admin API. Only
+// root can reach the socket.
+func (a *adminServer) serveUnix(addr string) error {
+	l, err := listenUnix(addr, 0, 0600)
+	if err != nil {
+		return err
+	}
+	apiLog.Infof("admin API listening on %s", addr)
+	return http.Serve(l, a.mux)
+}
+
+// listVolumes handles
+//
+//	GET /volumes
+//
+// with every volume, including those hidden from `docker volume ls`, and
+// its full status.
+func (a *adminServer) listVolumes(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.list").Debug()
+
+	writeAdminJSON(w, http.StatusOK, a.d.listVolumes())
+}
+
+// inspectVolume handles
+//
+//	GET /volumes/{name}
+func (a *adminServer) inspectVolume(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.inspect").Debug(r.PathValue("name"))
+
+	info, err := a.d.inspectVolume(r.PathValue("name"))
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, info)
+}
+
+// unmount handles
+//
+//	POST /volumes/{name}/unmount?force=true
+func (a *adminServer) unmount(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.unmount").Debug(r.PathValue("name"))
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if err := a.d.unmountVolume(r.PathValue("name"), force); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// remount handles
+//
+//	POST /volumes/{name}/remount?force=true
+func (a *adminServer) remount(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.remount").Debug(r.PathValue("name"))
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if err := a.d.remountVolume(r.PathValue("name"), force); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// maintain returns the handler of
+//
+//	POST /volumes/{name}/gc     {"delete": true}
+//	POST /volumes/{name}/fsck
+//	POST /volumes/{name}/warmup {"paths": ["models"], "threads": 8}
+//
+// which responds with the command's output. The body is optional.
+func (a *adminServer) maintain(op string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiLog.WithField("method", "admin."+op).Debug(r.PathValue("name"))
+
+		var req maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		out, err := a.d.maintainVolume(r.PathValue("name"), op, &req)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err := w.Write(out); err != nil {
+			apiLog.WithField("method", "admin."+op).Error(err)
+		}
+	}
+}
+
+// rotateCredentials handles
+//
+//	POST /volumes/{name}/rotate-credentials
+//	{"credentials": {"access-key": "...", "secret-key": "..."}, "remount": true}
+func (a *adminServer) rotateCredentials(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.rotate-credentials").Debug(r.PathValue("name"))
+
+	var req credentialRotation
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := a.d.rotateCredentials(r.PathValue("name"), &req); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// resetQuarantine handles
+//
+//	POST /volumes/{name}/reset-quarantine
+func (a *adminServer) resetQuarantine(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.reset-quarantine").Debug(r.PathValue("name"))
+
+	if err := a.d.resetQuarantine(r.PathValue("name")); err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// sessions handles
+//
+//	GET /volumes/{name}/sessions
+//
+// with the client sessions of a CE volume's filesystem on all nodes.
+func (a *adminServer) sessions(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.sessions").Debug(r.PathValue("name"))
+
+	sessions, err := a.d.volumeSessions(r.PathValue("name"))
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, sessions)
+}
+
+// cleanupSessions handles
+//
+//	POST /volumes/{name}/sessions/cleanup
+//
+// removing the stale sessions of a CE volume's filesystem.
+func (a *adminServer) cleanupSessions(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.cleanup-sessions").Debug(r.PathValue("name"))
+
+	result, err := a.d.cleanupSessions(r.PathValue("name"))
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+// profile handles
+//
+//	POST /volumes/{name}/profile?duration=30s
+//
+// and responds with the `juicefs profile` report of the volume's access log
+// recorded for duration (default 30s, at most maxProfileDuration).
+func (a *adminServer) profile(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.profile").Debug(r.PathValue("name"))
+
+	duration := 30 * time.Second
+	if val := r.URL.Query().Get("duration"); val != "" {
+		var err error
+		if duration, err = time.ParseDuration(val); err != nil || duration <= 0 || duration > maxProfileDuration {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid duration %q: must be positive and at most %s", val, maxProfileDuration))
+			return
+		}
+	}
+	report, err := a.d.profileVolume(r.PathValue("name"), duration)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := w.Write(report); err != nil {
+		apiLog.WithField("method", "admin.profile").Error(err)
+	}
+}
+
+// bench handles
+//
+//	POST /volumes/{name}/bench
+//	{"type": "bench", "threads": 4, "big_file_size": 256, "small_file_count": 200}
+//
+// and responds with the report of `juicefs bench` on the volume's mount or
+// `juicefs objbench` on its object store. The body is optional.
+func (a *adminServer) bench(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.bench").Debug(r.PathValue("name"))
+
+	var req benchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	report, err := a.d.benchVolume(r.PathValue("name"), &req)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := w.Write(report); err != nil {
+		apiLog.WithField("method", "admin.bench").Error(err)
+	}
+}
+
+// orphans handles
+//
+//	GET /orphans
+//
+// with the volumes whose filesystem was missing or whose meta engine was
+// unreachable when last checked.
+func (a *adminServer) orphans(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.orphans").Debug()
+
+	writeAdminJSON(w, http.StatusOK, orphans())
+}
+
+// checkOrphans handles
+//
+//	POST /orphans/check
+//
+// checking all volumes right away and responding like orphans.
+func (a *adminServer) checkOrphans(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.check-orphans").Debug()
+
+	if err := a.d.checkOrphans(); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, orphans())
+}
+
+// diagnostics handles
+//
+//	GET /diagnostics
+//
+// and responds with a gzipped tarball for bug reports.
+func (a *adminServer) diagnostics(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.diagnostics").Debug()
+
+	var buf bytes.Buffer
+	if err := a.d.writeDiagnostics(&buf); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	name := fmt.Sprintf("jfs-diagnostics-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if _, err := buf.WriteTo(w); err != nil {
+		apiLog.WithField("method", "admin.diagnostics").Error(err)
+	}
+}
+
+// report handles
+//
+//	GET /report?format=text
+//
+// with the operational report as JSON, or as plain text for support tickets.
+func (a *adminServer) report(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.report").Debug()
+
+	rep := a.d.report()
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeAdminJSON(w, http.StatusOK, rep)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := rep.writeText(w); err != nil {
+			apiLog.WithField("method", "admin.report").Error(err)
+		}
+	default:
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q, expected json or text", format))
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLog.WithField("method", "admin").Error(err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// history handles
+//
+//	GET /volumes/{name}/history?since=24h&until=2024-05-01T06:00:00Z
+//
+// with the recorded lifecycle events of a volume, including removed ones.
+// since and until are RFC 3339 times or durations before now; by default
+// the last 24 hours are returned.
+func (a *adminServer) history(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.history").Debug(r.PathValue("name"))
+
+	h, ok := a.d.state.(historyBackend)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, fmt.Errorf("volume history requires STATE_BACKEND=sqlite"))
+		return
+	}
+	now := time.Now()
+	since, err := parseHistoryTime(r.URL.Query().Get("since"), now.Add(-24*time.Hour))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	until, err := parseHistoryTime(r.URL.Query().Get("until"), now)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := h.History(r.PathValue("name"), since, until)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, events)
+}
+
+// events handles
+//
+//	GET /volumes/{name}/events
+//
+// with the last events of a volume, kept with every state backend.
+func (a *adminServer) events(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.events").Debug(r.PathValue("name"))
+
+	if _, err := a.d.lookupVolume(r.PathValue("name")); err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, volumeEvents(r.PathValue("name")))
+}
+
+// parseHistoryTime parses an RFC 3339 time or a duration before now.
+func parseHistoryTime(val string, def time.Time) (time.Time, error) {
+	if val == "" {
+		return def, nil
+	}
+	if d, err := time.ParseDuration(val); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or a duration such as 12h", val)
+	}
+	return t, nil
+}
+
+// exportState handles
+//
+//	GET /state?strip-secrets=true
+//
+// with the volume definitions as a JSON document for importState.
+func (a *adminServer) exportState(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.export-state").Debug()
+
+	strip := r.URL.Query().Get("strip-secrets")
+	writeAdminJSON(w, http.StatusOK, a.d.exportState(strip != "" && isFlagEnabled(strip)))
+}
+
+// importState handles
+//
+//	POST /state
+//	{"version": 1, "volumes": {...}}
+//
+// adding the volumes of an export that do not exist on this node.
+func (a *adminServer) importState(w http.ResponseWriter, r *http.Request) {
+	apiLog.WithField("method", "admin.import-state").Debug()
+
+	var export stateExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	result, err := a.d.importState(&export)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
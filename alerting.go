@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// alertConfig configures notifications about mount failures. Unlike the
+// lifecycle webhook, which gets every event, the alert URL is only notified
+// when a threshold is crossed and when the failures stop.
+type alertConfig struct {
+	// URL receives the notifications as JSON with a Slack compatible text
+	// field.
+	URL string `json:"url"`
+	// Window is the period failures are counted over, by default 5m.
+	Window string `json:"window"`
+	// VolumeFailures is the number of failed mounts of one volume within
+	// the window that raises an alert, by default 3.
+	VolumeFailures int `json:"volume_failures"`
+	// NodeFailureRate is the fraction of failed mounts of all volumes on
+	// the node within the window that raises an alert, by default 0.5,
+	// once there were at least NodeMinAttempts (default 5) mounts.
+	NodeFailureRate float64 `json:"node_failure_rate"`
+	NodeMinAttempts int     `json:"node_min_attempts"`
+}
+
+// alertNotification is the payload posted to the alert URL.
+type alertNotification struct {
+	Text     string    `json:"text"`
+	Status   string    `json:"status"`
+	Alert    string    `json:"alert"`
+	Node     string    `json:"node"`
+	Volume   string    `json:"volume,omitempty"`
+	Failures int       `json:"failures"`
+	Attempts int       `json:"attempts,omitempty"`
+	Window   string    `json:"window"`
+	Time     time.Time `json:"time"`
+}
+
+type mountAttempt struct {
+	time   time.Time
+	failed bool
+}
+
+// alerter tracks mount attempts per volume and for the node. While the
+// node alert fires, typically because the meta engine is down, no volume
+// alerts are raised, so an outage yields one notification instead of one
+// per volume.
+type alerter struct {
+	sync.Mutex
+	config alertConfig
+	window time.Duration
+	node   string
+
+	attempts  []mountAttempt
+	failures  map[string][]time.Time
+	nodeFired bool
+	fired     map[string]bool
+}
+
+// setupAlerting registers the alerter as an event sink.
+func setupAlerting(config *alertConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.URL == "" {
+		return fmt.Errorf("alerting requires url")
+	}
+	a := &alerter{config: *config, window: 5 * time.Minute, failures: map[string][]time.Time{}, fired: map[string]bool{}}
+	if config.Window != "" {
+		d, err := time.ParseDuration(config.Window)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid alerting window %q", config.Window)
+		}
+		a.window = d
+	}
+	if a.config.VolumeFailures <= 0 {
+		a.config.VolumeFailures = 3
+	}
+	if a.config.NodeFailureRate <= 0 {
+		a.config.NodeFailureRate = 0.5
+	}
+	if a.config.NodeMinAttempts <= 0 {
+		a.config.NodeMinAttempts = 5
+	}
+	a.node, _ = os.Hostname()
+	addEventSink(a.observe)
+	// Failures age out of the window without further events too.
+	schedule("alerting", time.Minute, func() error {
+		a.observe(event{Time: time.Now().UTC()})
+		return nil
+	})
+	return nil
+}
+
+// observe updates the failure counts with a mount event and sends the
+// notifications for thresholds crossed in either direction.
+func (a *alerter) observe(e event) {
+	var failed bool
+	switch e.Type {
+	case "mount_failed", "remount_failed":
+		failed = true
+	case "mounted", "remounted", "":
+	default:
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	cutoff := e.Time.Add(-a.window)
+	if e.Type != "" {
+		a.attempts = append(a.attempts, mountAttempt{time: e.Time, failed: failed})
+		if failed {
+			a.failures[e.Volume] = append(a.failures[e.Volume], e.Time)
+		}
+	}
+	for len(a.attempts) > 0 && a.attempts[0].time.Before(cutoff) {
+		a.attempts = a.attempts[1:]
+	}
+	for name, times := range a.failures {
+		for len(times) > 0 && times[0].Before(cutoff) {
+			times = times[1:]
+		}
+		a.failures[name] = times
+		if len(times) == 0 {
+			delete(a.failures, name)
+		}
+	}
+
+	nodeFailures := 0
+	for _, at := range a.attempts {
+		if at.failed {
+			nodeFailures++
+		}
+	}
+	rate := 0.0
+	if len(a.attempts) > 0 {
+		rate = float64(nodeFailures) / float64(len(a.attempts))
+	}
+	nodeFiring := len(a.attempts) >= a.config.NodeMinAttempts && rate >= a.config.NodeFailureRate
+	if nodeFiring != a.nodeFired && (nodeFiring || rate < a.config.NodeFailureRate) {
+		a.nodeFired = nodeFiring
+		n := a.notification("node_mount_failures", "", nodeFiring, nodeFailures, e.Time)
+		n.Attempts = len(a.attempts)
+		if nodeFiring {
+			n.Text = fmt.Sprintf("[FIRING] %d of %d mounts on %s failed in the last %s", nodeFailures, len(a.attempts), a.node, a.window)
+		} else {
+			n.Text = fmt.Sprintf("[RESOLVED] mounts on %s are succeeding again", a.node)
+		}
+		go a.send(n)
+	}
+
+	if e.Volume == "" {
+		return
+	}
+	count := len(a.failures[e.Volume])
+	switch {
+	case failed && count >= a.config.VolumeFailures && !a.fired[e.Volume] && !a.nodeFired:
+		a.fired[e.Volume] = true
+		n := a.notification("volume_mount_failures", e.Volume, true, count, e.Time)
+		n.Text = fmt.Sprintf("[FIRING] volume %s failed to mount %d times on %s in the last %s: %s", e.Volume, count, a.node, a.window, e.Message)
+		go a.send(n)
+	case !failed && a.fired[e.Volume]:
+		delete(a.fired, e.Volume)
+		n := a.notification("volume_mount_failures", e.Volume, false, count, e.Time)
+		n.Text = fmt.Sprintf("[RESOLVED] volume %s mounted on %s", e.Volume, a.node)
+		go a.send(n)
+	}
+}
+
+func (a *alerter) notification(alert, volume string, firing bool, failures int, t time.Time) alertNotification {
+	status := "resolved"
+	if firing {
+		status = "firing"
+	}
+	return alertNotification{
+		Status:   status,
+		Alert:    alert,
+		Node:     a.node,
+		Volume:   volume,
+		Failures: failures,
+		Window:   a.window.String(),
+		Time:     t,
+	}
+}
+
+func (a *alerter) send(n alertNotification) {
+	log := logrus.WithFields(logrus.Fields{"alert": n.Alert, "status": n.Status})
+	data, err := json.Marshal(n)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	resp, err := webhookClient.Post(a.config.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Warnf("alert delivery failed: %s", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Warnf("alert URL returned %s", resp.Status)
+	}
+}
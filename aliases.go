@@ -0,0 +1,88 @@
+package main
+
+import "fmt"
+
+// legacyOptionNames are deprecated spellings of options, accepted in
+// place of their canonical names.
+var legacyOptionNames = map[string]string{
+	"accesskey":  "access-key",
+	"accesskey2": "access-key2",
+	"secretkey":  "secret-key",
+	"secretkey2": "secret-key2",
+}
+
+// optionAliases are the option names an operator maps to canonical ones
+// with `option_aliases` in the configuration file, e.g. names of another
+// volume plugin. They are set once at startup.
+var optionAliases = map[string]string{}
+
+// addOptionAliases installs the configured aliases. An alias may not
+// shadow a canonical option name or point to another alias.
+func addOptionAliases(aliases map[string]string) error {
+	for alias, canonical := range aliases {
+		if alias == canonical {
+			continue
+		}
+		if _, ok := aliases[canonical]; ok {
+			return fmt.Errorf("option alias %s points to alias %s", alias, canonical)
+		}
+		if isKnownOption(alias) {
+			return fmt.Errorf("option alias %s shadows an option of the same name", alias)
+		}
+		optionAliases[alias] = canonical
+	}
+	return nil
+}
+
+// isKnownOption reports whether k is an option name of the plugin or of
+// the CE client.
+func isKnownOption(k string) bool {
+	if _, ok := driverOptions[k]; ok {
+		return true
+	}
+	if _, ok := ceMountOptions[k]; ok {
+		return true
+	}
+	for _, o := range ceFormatOptions {
+		if o == k {
+			return true
+		}
+	}
+	return k == "name" || k == "metaurl" || isCredentialKey(k)
+}
+
+// canonicalize returns the canonical name of option k.
+func canonicalize(k string) string {
+	if canonical, ok := optionAliases[k]; ok {
+		return canonical
+	}
+	if canonical, ok := legacyOptionNames[k]; ok {
+		return canonical
+	}
+	return k
+}
+
+// aliasesOf returns the names, including k itself, that canonicalize to
+// the canonical option k.
+func aliasesOf(k string) []string {
+	names := []string{k}
+	for _, table := range []map[string]string{legacyOptionNames, optionAliases} {
+		for alias, canonical := range table {
+			if canonical == k {
+				names = append(names, alias)
+			}
+		}
+	}
+	return names
+}
+
+// optionValue returns the value of option k of opts given under any of
+// its names.
+func optionValue(opts map[string]string, k string) string {
+	for _, name := range aliasesOf(k) {
+		if val := opts[name]; val != "" {
+			return val
+		}
+	}
+	return ""
+}
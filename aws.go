@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imdsEndpoint is the EC2 instance metadata service.
+const imdsEndpoint = "http://169.254.169.254"
+
+func init() {
+	registerSecretResolver("aws-sm", newCachingResolver(awsSecretsManager{}))
+	registerSecretResolver("aws-ssm", newCachingResolver(awsParameterStore{}))
+}
+
+var awsHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// awsSecretsManager resolves `aws-sm://SECRET-ID[#KEY]`. The secret ID is
+// a name or ARN; KEY selects a field of a JSON secret.
+type awsSecretsManager struct{}
+
+func (awsSecretsManager) Resolve(ref string) (string, error) {
+	id, key, _ := strings.Cut(ref, "#")
+	var resp struct {
+		SecretString string
+	}
+	if err := awsCall("secretsmanager", regionFromARN(id), "secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": id}, &resp); err != nil {
+		return "", err
+	}
+	return jsonField(resp.SecretString, key)
+}
+
+// awsParameterStore resolves `aws-ssm://NAME` (e.g. aws-ssm:///jfs/token)
+// with decryption of SecureString parameters.
+type awsParameterStore struct{}
+
+func (awsParameterStore) Resolve(name string) (string, error) {
+	var resp struct {
+		Parameter struct {
+			Value string
+		}
+	}
+	if err := awsCall("ssm", regionFromARN(name), "AmazonSSM.GetParameter", map[string]interface{}{"Name": name, "WithDecryption": true}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Parameter.Value, nil
+}
+
+// jsonField returns field key of a JSON object, or the whole value if key
+// is empty.
+func jsonField(value, key string) (string, error) {
+	if key == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %s", err)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(field), nil
+}
+
+// regionFromARN returns the region of an ARN, or "" for plain names.
+func regionFromARN(id string) string {
+	parts := strings.Split(id, ":")
+	if len(parts) > 3 && parts[0] == "arn" {
+		return parts[3]
+	}
+	return ""
+}
+
+// awsCall makes a JSON 1.1 API call, signed with the credentials of the
+// environment or of the instance's IAM role.
+func awsCall(service, region, target string, input, output interface{}) error {
+	creds, err := awsCreds()
+	if err != nil {
+		return err
+	}
+	if region == "" {
+		if region, err = awsRegion(); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signV4(req, body, creds, region, service, time.Now())
+	resp, err := awsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", service, target, resp.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, output)
+}
+
+// awsRegion returns AWS_REGION, AWS_DEFAULT_REGION or the instance's region.
+func awsRegion() (string, error) {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region, nil
+		}
+	}
+	region, err := imdsGet("/latest/meta-data/placement/region")
+	if err != nil {
+		return "", fmt.Errorf("cannot determine AWS region, set AWS_REGION: %s", err)
+	}
+	return region, nil
+}
+
+var instanceCreds struct {
+	sync.Mutex
+	creds   awsCredentials
+	expires time.Time
+}
+
+// awsCreds returns the credentials from AWS_ACCESS_KEY_ID and friends or,
+// without them, the temporary credentials of the instance's IAM role.
+func awsCreds() (awsCredentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return awsCredentials{
+			AccessKey:    key,
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	instanceCreds.Lock()
+	defer instanceCreds.Unlock()
+	if time.Now().Before(instanceCreds.expires) {
+		return instanceCreds.creds, nil
+	}
+	role, err := imdsGet("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials: %s", err)
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	data, err := imdsGet("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var resp struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		return awsCredentials{}, err
+	}
+	instanceCreds.creds = awsCredentials{AccessKey: resp.AccessKeyId, SecretKey: resp.SecretAccessKey, SessionToken: resp.Token}
+	// Refresh well before the credentials expire.
+	instanceCreds.expires = resp.Expiration.Add(-5 * time.Minute)
+	return instanceCreds.creds, nil
+}
+
+// imdsGet reads a path from the instance metadata service using IMDSv2.
+func imdsGet(path string) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	token, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IMDS token: %s", resp.Status)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, imdsEndpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	resp, err = client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IMDS %s: %s", path, resp.Status)
+	}
+	return string(data), nil
+}
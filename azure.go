@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const azureIMDSTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+func init() {
+	registerSecretResolver("azkv", newCachingResolver(azureKeyVault{}))
+}
+
+var azureHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// azureKeyVault resolves `azkv://VAULT/SECRET[/VERSION]` with the managed
+// identity of the Azure VM. VAULT is a vault name or, for sovereign clouds,
+// its full host name.
+type azureKeyVault struct{}
+
+func (azureKeyVault) Resolve(ref string) (string, error) {
+	vault, secret, ok := strings.Cut(strings.Trim(ref, "/"), "/")
+	if !ok || vault == "" || secret == "" {
+		return "", fmt.Errorf("invalid secret %q, expected VAULT/SECRET[/VERSION]", ref)
+	}
+	if !strings.Contains(vault, ".") {
+		vault += ".vault.azure.net"
+	}
+	token, err := azureToken()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://"+vault+"/secrets/"+secret+"?api-version=7.4", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := doJSON(azureHTTPClient, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.Value, nil
+}
+
+var azureAccessToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// azureToken returns a Key Vault access token of the VM's managed identity.
+// AZURE_CLIENT_ID selects a user-assigned identity.
+func azureToken() (string, error) {
+	azureAccessToken.Lock()
+	defer azureAccessToken.Unlock()
+	if time.Now().Before(azureAccessToken.expires) {
+		return azureAccessToken.token, nil
+	}
+
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {"https://vault.azure.net"},
+	}
+	if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+		query.Set("client_id", id)
+	}
+	req, err := http.NewRequest(http.MethodGet, azureIMDSTokenURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in,string"`
+	}
+	if err := doJSON(azureHTTPClient, req, &resp); err != nil {
+		return "", fmt.Errorf("no Azure managed identity: %s", err)
+	}
+	azureAccessToken.token = resp.AccessToken
+	azureAccessToken.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - 5*time.Minute)
+	return resp.AccessToken, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchRequest selects the benchmark of the bench admin action and its
+// parameters, bounded so a benchmark cannot fill the filesystem or run for
+// hours.
+type benchRequest struct {
+	// Type is "bench" (default), `juicefs bench` on the volume's mount,
+	// or "objbench", `juicefs objbench` on the volume's object store (CE
+	// only).
+	Type string `json:"type"`
+	// Threads is the number of concurrent workers, 1 to 16 (default 1).
+	Threads int `json:"threads"`
+	// BigFileSize is the size of the big files or objects in MiB, up to
+	// 1024 (default 128).
+	BigFileSize int `json:"big_file_size"`
+	// SmallFileCount is the number of small files or objects, up to 1000
+	// (default 100).
+	SmallFileCount int `json:"small_file_count"`
+}
+
+const benchTimeout = 10 * time.Minute
+
+// benchRunning allows one benchmark at a time.
+var benchRunning sync.Mutex
+
+func (r *benchRequest) check() error {
+	if r.Type == "" {
+		r.Type = "bench"
+	}
+	if r.Type != "bench" && r.Type != "objbench" {
+		return fmt.Errorf("invalid type %q: must be bench or objbench", r.Type)
+	}
+	for _, p := range []struct {
+		name       string
+		val        *int
+		def, limit int
+	}{
+		{"threads", &r.Threads, 1, 16},
+		{"big_file_size", &r.BigFileSize, 128, 1024},
+		{"small_file_count", &r.SmallFileCount, 100, 1000},
+	} {
+		if *p.val == 0 {
+			*p.val = p.def
+		}
+		if *p.val < 1 || *p.val > p.limit {
+			return fmt.Errorf("invalid %s %d: must be between 1 and %d", p.name, *p.val, p.limit)
+		}
+	}
+	return nil
+}
+
+// benchVolume runs the requested benchmark for a volume and returns its
+// report.
+func (d *jfsDriver) benchVolume(name string, r *benchRequest) ([]byte, error) {
+	if err := r.check(); err != nil {
+		return nil, codedError(codeInvalidOption, "%s", err)
+	}
+	d.RLock()
+	v, ok := d.volumes[name]
+	d.RUnlock()
+	if !ok {
+		return nil, codedError(codeVolumeNotFound, "volume %s not found", name)
+	}
+	if !benchRunning.TryLock() {
+		return nil, fmt.Errorf("another benchmark is running")
+	}
+	defer benchRunning.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), benchTimeout)
+	defer cancel()
+	threads := strconv.Itoa(r.Threads)
+	var cmd *exec.Cmd
+	var secrets []string
+	switch r.Type {
+	case "bench":
+		if !isJuiceFSMountedRoot(v.Mountpoint) {
+			return nil, fmt.Errorf("volume %s is not mounted on this node", name)
+		}
+		if isReadOnly(v) {
+			return nil, fmt.Errorf("volume %s is mounted read-only", name)
+		}
+		cli := eeCliPath
+		if isCommunityEdition(v) {
+			cli = ceCliPath
+		}
+		cmd = exec.CommandContext(ctx, cli, "bench", v.Mountpoint,
+			"--threads", threads,
+			"--big-file-size", strconv.Itoa(r.BigFileSize),
+			"--small-file-count", strconv.Itoa(r.SmallFileCount))
+		cmd.Env = os.Environ()
+	case "objbench":
+		if !isCommunityEdition(v) {
+			return nil, fmt.Errorf("objbench needs the storage options of a Community Edition volume")
+		}
+		opts, err := mountOptions(v)
+		if err != nil {
+			return nil, err
+		}
+		bucket := opts["bucket"]
+		if bucket == "" {
+			return nil, fmt.Errorf("volume %s has no bucket option", name)
+		}
+		secrets = volumeSecrets(v, opts)
+		cmd = exec.CommandContext(ctx, ceCliPath, "objbench", strings.Replace(bucket, "%d", "0", 1),
+			"--skip-functional-tests",
+			"--threads", threads,
+			"--big-object-size", strconv.Itoa(r.BigFileSize),
+			"--small-objects", strconv.Itoa(r.SmallFileCount))
+		if storage := opts["storage"]; storage != "" {
+			cmd.Args = append(cmd.Args, "--storage", storage)
+		}
+		// The credentials are passed in the environment, as for format.
+		if cmd.Env, err = optionEnv(opts); err != nil {
+			return nil, err
+		}
+	}
+	cmd.Env = append(cmd.Env, "JFS_NO_UPDATE=1")
+	cliLog.Debug(sanitizeOutput(cmd.String(), secrets))
+	out, err := cmd.CombinedOutput()
+	recordOutput(v, cmd, out, err, secrets)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("juicefs %s for volume %s did not finish within %s", r.Type, name, benchTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("juicefs %s failed for volume %s: %s", r.Type, name, sanitizeOutput(string(bytes.TrimSpace(out)), secrets))
+	}
+	return []byte(sanitizeOutput(string(out), secrets)), nil
+}
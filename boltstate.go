@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltVolumesBucket = []byte("volumes")
+	boltEventsBucket  = []byte("events")
+)
+
+// boltMaxEvents is how many of the most recent events the bolt backend
+// keeps.
+const boltMaxEvents = 10000
+
+// boltBackend keeps the state in a bbolt database with one key per volume,
+// so a save writes only the volumes that changed, in a single transaction
+// that either commits completely or not at all. Volumes carry their mount
+// IDs and client PIDs; the most recent events are kept as well.
+type boltBackend struct {
+	db   *bolt.DB
+	path string
+	// saved holds the last saved encoding of each volume.
+	saved  map[string][]byte
+	events chan event
+}
+
+func newBoltBackend(root string) (*boltBackend, error) {
+	path := filepath.Join(root, "state", "jfs-state.db")
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltVolumesBucket, boltEventsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	b := &boltBackend{db: db, path: path, saved: map[string][]byte{}, events: make(chan event, 256)}
+	go b.writeEvents()
+	addEventSink(b.recordEvent)
+	return b, nil
+}
+
+func (b *boltBackend) String() string {
+	return b.path
+}
+
+// Load reads all volumes. An empty database takes over the volumes of the
+// JSON state file, so switching to the bolt backend keeps existing volumes.
+func (b *boltBackend) Load() (map[string]*jfsVolume, error) {
+	volumes := map[string]*jfsVolume{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltVolumesBucket).ForEach(func(k, data []byte) error {
+			v := &jfsVolume{}
+			if err := json.Unmarshal(data, v); err != nil {
+				return fmt.Errorf("volume %s: %s", k, err)
+			}
+			volumes[string(k)] = v
+			b.saved[string(k)] = bytes.Clone(data)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(volumes) > 0 {
+		return volumes, nil
+	}
+
+	file := &fileBackend{path: filepath.Join(filepath.Dir(b.path), "jfs-state.json")}
+	volumes, err = file.Load()
+	if err != nil || volumes == nil {
+		return nil, err
+	}
+	logrus.WithField("statePath", b.path).Infof("importing %d volumes from %s", len(volumes), file)
+	return volumes, b.Save(volumes)
+}
+
+// Save writes the volumes that changed since the last save and deletes the
+// removed ones.
+func (b *boltBackend) Save(volumes map[string]*jfsVolume) error {
+	encoded, changed, err := encodeChangedVolumes(b.saved, volumes)
+	if err != nil || !changed {
+		return err
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltVolumesBucket)
+		for name, data := range encoded {
+			if bytes.Equal(b.saved[name], data) {
+				continue
+			}
+			if err := bucket.Put([]byte(name), data); err != nil {
+				return err
+			}
+		}
+		for name := range b.saved {
+			if _, ok := encoded[name]; !ok {
+				if err := bucket.Delete([]byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.saved = encoded
+	return nil
+}
+
+// recordEvent queues an event for writeEvents. Events are dropped rather
+// than delay the operation that emitted them.
+func (b *boltBackend) recordEvent(e event) {
+	select {
+	case b.events <- e:
+	default:
+		logrus.WithField("event", e.Type).Warn("state database is busy, event not recorded")
+	}
+}
+
+// writeEvents appends queued events under a growing sequence number and
+// drops the oldest beyond boltMaxEvents.
+func (b *boltBackend) writeEvents() {
+	for e := range b.events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(boltEventsBucket)
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(boltKey(seq), data); err != nil {
+				return err
+			}
+			if seq > boltMaxEvents {
+				return bucket.Delete(boltKey(seq - boltMaxEvents))
+			}
+			return nil
+		})
+		if err != nil {
+			logrus.WithField("event", e.Type).Warnf("cannot record event: %s", err)
+		}
+	}
+}
+
+func boltKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientMetricPrefixes selects the series re-exported from each client's
+// `.stats` file: object storage operations, cache hits, FUSE latency and
+// buffer/staging usage.
+var clientMetricPrefixes = []string{
+	"juicefs_object_request",
+	"juicefs_blockcache",
+	"juicefs_fuse",
+	"juicefs_meta_ops",
+	"juicefs_transaction",
+	"juicefs_used_buffer",
+	"juicefs_staging",
+}
+
+// clientCollector re-exports key metrics of every mounted JuiceFS client
+// with a volume label, so a single scrape of the plugin covers all mounts.
+// It is an unchecked collector because the set of series depends on the
+// client version.
+type clientCollector struct {
+	d *jfsDriver
+}
+
+func (c *clientCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *clientCollector) Collect(ch chan<- prometheus.Metric) {
+	c.d.RLock()
+	mountpoints := map[string]string{}
+	for name, v := range c.d.volumes {
+		mountpoints[name] = v.Mountpoint
+	}
+	c.d.RUnlock()
+
+	for name, mountpoint := range mountpoints {
+		if !isJuiceFSMountedRoot(mountpoint) {
+			continue
+		}
+		stats, err := readMountStats(mountpoint)
+		if err != nil {
+			monitorLog.WithField("volume", name).Debugf("cannot read client stats: %s", err)
+			continue
+		}
+		for series, val := range stats {
+			metric, labels := parseSeries(series)
+			if !hasClientMetricPrefix(metric) {
+				continue
+			}
+			keys := []string{"volume"}
+			values := []string{name}
+			for _, k := range sortedKeys(labels) {
+				keys = append(keys, k)
+				values = append(values, labels[k])
+			}
+			desc := prometheus.NewDesc(metric, "JuiceFS client metric "+metric+".", keys, nil)
+			m, err := prometheus.NewConstMetric(desc, prometheus.UntypedValue, val, values...)
+			if err != nil {
+				continue
+			}
+			ch <- m
+		}
+	}
+}
+
+func hasClientMetricPrefix(metric string) bool {
+	for _, p := range clientMetricPrefixes {
+		if strings.HasPrefix(metric, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSeries splits `name{k="v",...}` into the metric name and its labels.
+func parseSeries(series string) (string, map[string]string) {
+	i := strings.IndexByte(series, '{')
+	if i < 0 || !strings.HasSuffix(series, "}") {
+		return series, nil
+	}
+	labels := map[string]string{}
+	for _, pair := range strings.Split(series[i+1:len(series)-1], ",") {
+		k, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		labels[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return series[:i], labels
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
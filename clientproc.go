@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clientProcess identifies the juicefs client serving a volume. The start
+// time, in clock ticks since boot, tells the client apart from an unrelated
+// process that reused its PID after a crash or reboot.
+type clientProcess struct {
+	PID       int    `json:"pid"`
+	StartTime uint64 `json:"start_time"`
+}
+
+// processStartTime reads the start time of a process from /proc/PID/stat.
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name in parentheses may contain spaces; fields are
+	// counted from the closing parenthesis, which precedes field 3.
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// trackClient records the client process serving the volume's mountpoint.
+func trackClient(v *jfsVolume) {
+	v.client.Store(nil)
+	pid := findMountProcess(v.Mountpoint)
+	if pid == 0 {
+		mountLog.Debugf("no client process found for %s", v.Mountpoint)
+		return
+	}
+	start, err := processStartTime(pid)
+	if err != nil {
+		mountLog.Debugf("cannot read start time of client %d: %s", pid, err)
+		return
+	}
+	v.client.Store(&clientProcess{PID: pid, StartTime: start})
+}
+
+// alive reports whether the recorded client is still running.
+func (c *clientProcess) alive() bool {
+	start, err := processStartTime(c.PID)
+	return err == nil && start == c.StartTime
+}
+
+// forgetDeadClients drops client records from a previous run whose process
+// is gone. The caller holds d's lock.
+func (d *jfsDriver) forgetDeadClients() {
+	for name, v := range d.volumes {
+		if c := v.client.Load(); c != nil && !c.alive() {
+			mountLog.WithField("volume", name).Infof("client %d from the previous run is gone", c.PID)
+			v.client.Store(nil)
+		}
+	}
+}
+
+// loadClients restores the client records saved with the volumes.
+func loadClients(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.client.Store(v.Client)
+	}
+}
+
+// storeClients copies the client records into the volumes to be saved with
+// them. The caller holds d's lock.
+func storeClients(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.Client = v.client.Load()
+	}
+}
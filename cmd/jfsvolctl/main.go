@@ -0,0 +1,257 @@
+// jfsvolctl talks to the admin API of the JuiceFS volume plugin so that
+// operators do not have to curl its unix socket.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultSocket is the admin socket inside the plugin. On the host it is
+// found under the plugin ID, see findSocket.
+const defaultSocket = "/run/docker/plugins/jfs-admin.sock"
+
+const usage = `Usage: jfsvolctl [-socket PATH] COMMAND [ARGS]
+
+Commands:
+  list                          list volumes and whether they are mounted
+  inspect NAME                  show the full status of a volume
+  remount NAME                  remount a mounted volume no container uses
+  force-remount NAME            remount a volume even if containers use it
+  unmount NAME                  unmount a volume no container uses
+  force-unmount NAME            unmount a volume even if containers use it
+  report                        print the operational report for support tickets
+  snapshot [-strip-secrets] [-o FILE]
+                                save the volume definitions for POST /state
+`
+
+type client struct {
+	http *http.Client
+}
+
+func newClient(socket string) *client {
+	return &client{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+		// Remounts wait for the mount to become ready.
+		Timeout: 10 * time.Minute,
+	}}
+}
+
+// do sends a request to the admin API and returns the response body. Error
+// responses are returned as errors with the message of the plugin.
+func (c *client) do(method, path string) ([]byte, error) {
+	req, err := http.NewRequest(method, "http://admin"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s", e.Error)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return body, nil
+}
+
+// findSocket returns the admin socket to use: the one inside the plugin if
+// it exists, otherwise the only one below a plugin ID on the host.
+func findSocket() (string, error) {
+	if _, err := os.Stat(defaultSocket); err == nil {
+		return defaultSocket, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(defaultSocket), "*", filepath.Base(defaultSocket)))
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no admin socket found, is the plugin enabled? Use -socket to point at it")
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("several admin sockets found (%s), use -socket to pick one", strings.Join(matches, ", "))
+	}
+}
+
+type volume struct {
+	Name        string                 `json:"name"`
+	Mountpoint  string                 `json:"mountpoint"`
+	Connections int                    `json:"connections"`
+	Status      map[string]interface{} `json:"status"`
+}
+
+func list(c *client) error {
+	body, err := c.do(http.MethodGet, "/volumes")
+	if err != nil {
+		return err
+	}
+	var volumes []volume
+	if err := json.Unmarshal(body, &volumes); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tEDITION\tMOUNTED\tCONTAINERS\tMOUNTPOINT")
+	for _, v := range volumes {
+		mounted := v.Status["mounted"] == true
+		fmt.Fprintf(w, "%s\t%v\t%t\t%d\t%s\n", v.Name, v.Status["edition"], mounted, v.Connections, v.Mountpoint)
+	}
+	return w.Flush()
+}
+
+func inspect(c *client, name string) error {
+	body, err := c.do(http.MethodGet, "/volumes/"+url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	return printJSON(os.Stdout, body)
+}
+
+func unmount(c *client, name string, force bool) error {
+	path := "/volumes/" + url.PathEscape(name) + "/unmount"
+	if force {
+		path += "?force=true"
+	}
+	if _, err := c.do(http.MethodPost, path); err != nil {
+		return err
+	}
+	fmt.Printf("unmounted %s\n", name)
+	return nil
+}
+
+func remount(c *client, name string, force bool) error {
+	path := "/volumes/" + url.PathEscape(name) + "/remount"
+	if force {
+		path += "?force=true"
+	}
+	if _, err := c.do(http.MethodPost, path); err != nil {
+		return err
+	}
+	fmt.Printf("remounted %s\n", name)
+	return nil
+}
+
+func report(c *client) error {
+	body, err := c.do(http.MethodGet, "/report?format=text")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+// snapshot saves the volume definitions of the plugin, which can be restored
+// on this or another node with POST /state.
+func snapshot(c *client, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	strip := fs.Bool("strip-secrets", false, "leave out credentials")
+	out := fs.String("o", "", "write to `FILE` instead of stdout")
+	fs.Parse(args)
+
+	path := "/state"
+	if *strip {
+		path += "?strip-secrets=true"
+	}
+	body, err := c.do(http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return printJSON(os.Stdout, body)
+	}
+	var buf bytes.Buffer
+	if err := printJSON(&buf, body); err != nil {
+		return err
+	}
+	// The snapshot contains credentials unless they are stripped.
+	return os.WriteFile(*out, buf.Bytes(), 0600)
+}
+
+func printJSON(w io.Writer, body []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func main() {
+	socket := flag.String("socket", os.Getenv("JFSVOLCTL_SOCKET"), "admin socket of the plugin")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *socket == "" {
+		var err error
+		if *socket, err = findSocket(); err != nil {
+			fmt.Fprintln(os.Stderr, "jfsvolctl:", err)
+			os.Exit(1)
+		}
+	}
+	c := newClient(*socket)
+
+	name := func() string {
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "Usage: jfsvolctl %s NAME\n", args[0])
+			os.Exit(2)
+		}
+		return args[1]
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		err = list(c)
+	case "inspect":
+		err = inspect(c, name())
+	case "remount":
+		err = remount(c, name(), false)
+	case "force-remount":
+		err = remount(c, name(), true)
+	case "unmount":
+		err = unmount(c, name(), false)
+	case "force-unmount":
+		err = unmount(c, name(), true)
+	case "report":
+		err = report(c)
+	case "snapshot":
+		err = snapshot(c, args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "jfsvolctl:", err)
+		os.Exit(1)
+	}
+}
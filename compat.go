@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// upstreamCompat is set with COMPAT_MODE=upstream. Create then accepts the
+// option spellings of the upstream juicedata/docker-volume-juicefs plugin
+// and translates them, so existing compose files and stacks keep working.
+var upstreamCompat bool
+
+// setCompatMode applies the COMPAT_MODE setting.
+func setCompatMode(mode string) error {
+	switch mode {
+	case "":
+	case "upstream":
+		upstreamCompat = true
+	default:
+		return fmt.Errorf("invalid COMPAT_MODE %q: must be empty or upstream", mode)
+	}
+	return nil
+}
+
+// compatOption translates option k=val given in the upstream plugin's
+// spelling. Upstream forwards every option verbatim as --k=val, so FUSE
+// style names with underscores reach the client and a flag set to false
+// stays off; here underscores become dashes where that names a known
+// option, the legacy credential names become canonical and EE flags set
+// to false are dropped, as EE passes flags without their value. keep is
+// false for options to drop.
+func compatOption(k, val string) (string, string, bool) {
+	if dashed := strings.ReplaceAll(k, "_", "-"); dashed != k && (isKnownOption(dashed) || isEEMountFlag(dashed)) {
+		k = dashed
+	}
+	if canonical, ok := legacyOptionNames[k]; ok {
+		k = canonical
+	}
+	if isEEMountFlag(k) && val != "" {
+		if b, err := strconv.ParseBool(val); err == nil && !b {
+			return k, val, false
+		}
+	}
+	return k, val, true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultConfigPath lives in the state mount so it survives plugin upgrades
+// and can be edited from the host.
+const defaultConfigPath = "/jfs/state/jfs-config.json"
+
+// driverConfig is the optional plugin configuration file. It holds
+// structured settings that do not fit into plugin environment variables.
+type driverConfig struct {
+	// Profiles are named option sets a volume selects with
+	// `profile=<name>`. Options given at Create override profile values.
+	Profiles map[string]map[string]string `json:"profiles"`
+	// List restricts which volumes List reports.
+	List listFilter `json:"list"`
+	// Tenants share the plugin; see tenantConfig.
+	Tenants map[string]*tenantConfig `json:"tenants"`
+	// RequireTenant rejects volumes that belong to no tenant.
+	RequireTenant bool `json:"require_tenant"`
+	// Policy restricts the options of new volumes.
+	Policy admissionPolicy `json:"policy"`
+	// StorageEnv maps storage types to the environment variables their
+	// credentials are exported as; see storageEnvVars.
+	StorageEnv map[string]map[string]string `json:"storage_env"`
+	// OptionAliases maps option names to canonical ones; see
+	// optionAliases.
+	OptionAliases map[string]string `json:"option_aliases"`
+	// FormatOptions are further options passed to `juicefs format`
+	// rather than `juicefs mount`; see addFormatOptions.
+	FormatOptions []string `json:"format_options"`
+	// MountFlags are further boolean flags of `juicefs mount`; see
+	// addMountFlags.
+	MountFlags mountFlags `json:"mount_flags"`
+	// NameDefaults give default options to volumes by name pattern.
+	NameDefaults []nameDefault `json:"name_defaults"`
+	// LogShipping pushes the logs to Loki or Fluentd.
+	LogShipping *logShipping `json:"log_shipping"`
+	// Alerting notifies about mount failure thresholds.
+	Alerting *alertConfig `json:"alerting"`
+}
+
+// loadConfig reads the configuration file. A missing file yields an empty
+// configuration.
+func loadConfig(path string) (*driverConfig, error) {
+	config := &driverConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.WithField("configPath", path).Debug("no config found")
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.compileNameDefaults(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// applyProfile merges the options of the profile selected with `profile=`,
+// a configured or a built-in one, underneath the options given at Create.
+func (d *jfsDriver) applyProfile(options map[string]string) (map[string]string, error) {
+	name, ok := options["profile"]
+	if !ok {
+		return options, nil
+	}
+	profile, ok := d.config.Profiles[name]
+	if !ok {
+		profile, ok = builtinProfileOptions(name, options)
+	}
+	if !ok {
+		return nil, logError("unknown profile %q", name)
+	}
+	merged := map[string]string{}
+	for k, val := range profile {
+		merged[k] = val
+	}
+	for k, val := range options {
+		merged[k] = val
+	}
+	return merged, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// eeOnlyOptions are understood by the Enterprise Edition client only.
+var eeOnlyOptions = []string{"external", "internal", "gc", "dry", "flip", "no-sync", "console-url", "base-url", "access-key2", "secret-key2", "bucket2"}
+
+// ceOnlyOptions configure `juicefs format`; Enterprise Edition volumes
+// keep these settings in the console.
+var ceOnlyOptions = []string{"block-size", "compress", "shards", "trash-days", "encrypt-rsa-key", "hash-prefix", "capacity", "inodes"}
+
+// checkConflicts rejects option combinations the client would refuse, or
+// worse accept and ignore, with a message naming both options. opts are the
+// canonical options of v.
+func checkConflicts(v *jfsVolume, opts map[string]string) error {
+	enabled := func(k string) bool {
+		val, ok := opts[k]
+		return ok && isFlagEnabled(val)
+	}
+	readOnly := enabled("read-only") || enabled("ro")
+
+	if readOnly && enabled("writeback") {
+		return fmt.Errorf("options read-only and writeback conflict: a read-only mount has no writes to stage")
+	}
+	if val, ok := opts["cache-size"]; ok && strings.TrimSpace(val) == "0" {
+		for _, k := range []string{"writeback", "cache-partial-only", "warmup"} {
+			if _, ok := opts[k]; ok {
+				return fmt.Errorf("options cache-size=0 and %s conflict: %s needs the local cache", k, k)
+			}
+		}
+	}
+	edition, other := "Community", eeOnlyOptions
+	if !isCommunityEdition(v) {
+		edition, other = "Enterprise", ceOnlyOptions
+	}
+	for _, k := range other {
+		if _, ok := opts[k]; ok {
+			return fmt.Errorf("option %s is not supported by %s Edition volumes", k, edition)
+		}
+	}
+	return nil
+}
+
+// checkVolumeConflicts runs checkConflicts on the stored options of a
+// volume, which may predate the checks.
+func checkVolumeConflicts(v *jfsVolume) error {
+	expanded, err := expandOptions(v)
+	if err != nil {
+		return err
+	}
+	opts := map[string]string{}
+	for k, val := range expanded {
+		opts[canonicalize(k)] = val
+	}
+	return checkConflicts(v, opts)
+}
+
+// checkSubdirFormatted fails for a CE volume mounting a subdir of a
+// filesystem that is not formatted yet: the format creates an empty
+// filesystem, so the subdir cannot exist and the mount would fail.
+func checkSubdirFormatted(v *jfsVolume, opts map[string]string) error {
+	subdir := opts["subdir"]
+	if subdir == "" || !isCommunityEdition(v) {
+		return nil
+	}
+	env, err := optionEnv(opts)
+	if err != nil {
+		return err
+	}
+	status := exec.Command(ceCliPath, "status", v.Source)
+	status.Env = env
+	out, err := status.CombinedOutput()
+	recordOutput(v, status, out, err, volumeSecrets(v, opts))
+	if err != nil && bytes.Contains(out, []byte("not formatted")) {
+		return fmt.Errorf("subdir %s cannot exist on a filesystem that is not formatted yet; create a volume without subdir first", subdir)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// credentialKeys are the volume options that can be changed by a credential
+// rotation. Legacy spellings are replaced by their canonical names.
+var credentialKeys = []string{"access-key", "secret-key", "access-key2", "secret-key2", "session-token", "token"}
+
+type credentialRotation struct {
+	Credentials map[string]string `json:"credentials"`
+	Remount     bool              `json:"remount"`
+}
+
+// rotateCredentials pushes new object storage credentials for a volume to
+// JuiceFS (`juicefs config` for CE, `juicefs auth` for EE), records them in
+// the driver state and optionally remounts the volume so the running client
+// picks them up. The CLI calls run under v.mu only, so other volumes are
+// not held up.
+func (d *jfsDriver) rotateCredentials(name string, r *credentialRotation) error {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return err
+	}
+	// Save the state once v.mu has been released, see Mount.
+	changed := false
+	defer func() {
+		if changed {
+			d.Lock()
+			d.saveState()
+			d.Unlock()
+		}
+	}()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	creds := map[string]string{}
+	for k, val := range r.Credentials {
+		k = canonicalize(k)
+		if !isCredentialKey(k) {
+			return logError("unsupported credential %q for volume %s", k, name)
+		}
+		if val == "" {
+			return logError("empty value for credential %q of volume %s", k, name)
+		}
+		if envBool("REQUIRE_SECRET_REFS", false) && !isSecretRef(val) {
+			return logError("credential %q of volume %s must be a secret reference", k, name)
+		}
+		creds[k] = val
+	}
+	if len(creds) == 0 {
+		return logError("no credentials given for volume %s", name)
+	}
+
+	opts := map[string]string{}
+	for k, val := range v.Options {
+		opts[k] = val
+	}
+	for k, val := range creds {
+		for _, name := range aliasesOf(k) {
+			delete(opts, name)
+		}
+		opts[k] = val
+	}
+
+	if err := pushCredentials(v, opts, creds); err != nil {
+		return err
+	}
+	old := v.Options
+	d.Lock()
+	v.Options = opts
+	d.Unlock()
+	changed = true
+	mountLog.WithField("volume", name).Info("credentials rotated")
+
+	if !r.Remount || v.connectionCount() == 0 {
+		return nil
+	}
+	// The containers keep the mount they use until they stop; the ones
+	// started from now on get a client with the new credentials.
+	if err := lazyUmountVolume(v); err != nil {
+		return logError("failed to remount %s after credential rotation: %s", name, err)
+	}
+	if err = mountVolume(v); err == nil {
+		return nil
+	}
+	err = logError("failed to remount %s after credential rotation, restoring the previous credentials: %s", name, err)
+	restoreCredentials(v, old, creds)
+	d.Lock()
+	v.Options = old
+	d.Unlock()
+	if err := mountVolume(v); err != nil {
+		logError("failed to remount %s with the previous credentials: %s", name, err)
+	}
+	return err
+}
+
+// pushCredentials stores the credentials creds, given in opts, in JuiceFS.
+// The CLI gets the secrets, the state keeps their references.
+func pushCredentials(v *jfsVolume, opts, creds map[string]string) error {
+	resolved, err := mountOptions(&jfsVolume{Name: v.Name, Options: opts, Source: v.Source, Mountpoint: v.Mountpoint})
+	if err != nil {
+		return logError("%s", err)
+	}
+	resolvedCreds := map[string]string{}
+	for k := range creds {
+		resolvedCreds[k] = optionValue(resolved, k)
+	}
+	if isCommunityEdition(v) {
+		return ceConfigCredentials(v, resolved, resolvedCreds)
+	}
+	env, secrets, err := eeEnv(v, resolved)
+	if err != nil {
+		return err
+	}
+	return eeAuth(v, env, resolved, secrets)
+}
+
+// restoreCredentials pushes the previous values of the rotated credentials
+// back to JuiceFS after the volume failed to mount with the new ones.
+// Credentials the volume did not have before are left alone.
+func restoreCredentials(v *jfsVolume, old, creds map[string]string) {
+	prev := map[string]string{}
+	for k := range creds {
+		if val := optionValue(old, k); val != "" {
+			prev[k] = val
+		}
+	}
+	if len(prev) == 0 {
+		return
+	}
+	if err := pushCredentials(v, old, prev); err != nil {
+		mountLog.WithField("volume", dockerName(v)).Warnf("cannot restore the previous credentials: %s", err)
+	}
+}
+
+// ceConfigCredentials updates the credentials stored in the CE meta engine
+// with `juicefs config META --access-key=... --secret-key=...`.
+func ceConfigCredentials(v *jfsVolume, opts, creds map[string]string) error {
+	config := exec.Command(ceCliPath, "config", v.Source)
+	env, err := optionEnv(opts)
+	if err != nil {
+		return logError("%s", err)
+	}
+	config.Env = env
+	var secrets []string
+	for _, k := range []string{"access-key", "secret-key", "session-token"} {
+		if val, ok := creds[k]; ok {
+			config.Args = append(config.Args, fmt.Sprintf("--%s=%s", k, val))
+			secrets = append(secrets, val)
+		}
+	}
+	if len(secrets) != len(creds) {
+		return logError("only access-key, secret-key and session-token can be rotated for CE volume %s", v.Name)
+	}
+	secrets = append(secrets, volumeSecrets(v, opts)...)
+	mountLog.Debug(sanitizeOutput(config.String(), secrets))
+	out, err := config.CombinedOutput()
+	recordOutput(v, config, out, err, secrets)
+	if err != nil {
+		msg := sanitizeOutput(string(bytes.TrimSpace(out)), secrets)
+		return logError("juicefs config failed for volume %s: %s", v.Name, msg)
+	}
+	return nil
+}
+
+func isCredentialKey(k string) bool {
+	for _, c := range credentialKeys {
+		if c == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// deprecationsWarned remembers the volume options a deprecation warning
+// was logged for, so each is logged once per plugin run.
+var deprecationsWarned sync.Map
+
+// deprecatedOptions maps the legacy option names a volume uses to their
+// canonical replacements.
+func deprecatedOptions(v *jfsVolume) map[string]string {
+	deprecated := map[string]string{}
+	for k := range v.Options {
+		if canonical, ok := legacyOptionNames[k]; ok {
+			deprecated[k] = canonical
+		}
+	}
+	return deprecated
+}
+
+// warnDeprecated logs a warning for each legacy option name of a volume,
+// once per volume and option. The names keep working until they are
+// removed in a future release.
+func warnDeprecated(name string, v *jfsVolume) {
+	deprecated := deprecatedOptions(v)
+	for _, k := range sortedKeys(deprecated) {
+		if _, warned := deprecationsWarned.LoadOrStore(name+"\x00"+k, true); warned {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"volume":      name,
+			"option":      k,
+			"replacement": deprecated[k],
+		}).Warnf("option %s is deprecated and will be removed in a future release, use %s", k, deprecated[k])
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCommandOutputs is the number of CLI invocations kept per volume.
+	maxCommandOutputs = 10
+	// maxBundledLogBytes is how much of the end of each log file is bundled.
+	maxBundledLogBytes = 1 << 20
+)
+
+// commandOutput is a sanitized record of one juicefs CLI invocation.
+type commandOutput struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Output  string    `json:"output"`
+	Error   string    `json:"error,omitempty"`
+	// RequestID is the API call the command ran for, if any.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+var commandOutputs = struct {
+	sync.Mutex
+	byVolume map[string][]commandOutput
+}{byVolume: map[string][]commandOutput{}}
+
+// recordOutput remembers the last CLI invocations of a volume for the
+// diagnostic bundle. Secrets are removed before anything is stored.
+func recordOutput(v *jfsVolume, cmd *exec.Cmd, out []byte, err error, secrets []string) {
+	rec := commandOutput{
+		Time:      time.Now().UTC(),
+		Command:   sanitizeOutput(cmd.String(), secrets),
+		Output:    sanitizeOutput(string(out), secrets),
+		RequestID: v.requestID(),
+	}
+	if err != nil {
+		rec.Error = sanitizeOutput(err.Error(), secrets)
+	}
+	name := dockerName(v)
+	commandOutputs.Lock()
+	defer commandOutputs.Unlock()
+	list := append(commandOutputs.byVolume[name], rec)
+	if len(list) > maxCommandOutputs {
+		list = list[len(list)-maxCommandOutputs:]
+	}
+	commandOutputs.byVolume[name] = list
+}
+
+// writeDiagnostics writes a gzipped tarball with sanitized state, recent
+// logs, client versions, JuiceFS mount table entries and the last command
+// outputs of every volume.
+func (d *jfsDriver) writeDiagnostics(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	state, err := d.sanitizedState()
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"state.json":    state,
+		"versions.txt":  cliVersions(),
+		"mountinfo.txt": juicefsMountinfo(d.root),
+	}
+	commandOutputs.Lock()
+	for name, list := range commandOutputs.byVolume {
+		data, _ := json.MarshalIndent(list, "", "  ")
+		files["commands/"+name+".json"] = data
+	}
+	commandOutputs.Unlock()
+	sanitizeLog := d.logSanitizer()
+	logs, _ := filepath.Glob(filepath.Join(logDir(), "*.log"))
+	clientLogs, _ := filepath.Glob(filepath.Join(volumeLogDir(), "*.log"))
+	for _, path := range append(logs, clientLogs...) {
+		if data, err := tailFile(path, maxBundledLogBytes); err == nil {
+			rel, _ := filepath.Rel(logDir(), path)
+			files["logs/"+filepath.ToSlash(rel)] = []byte(sanitizeLog(string(data)))
+		}
+	}
+
+	for name, data := range files {
+		if err := add(name, data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// logSanitizer returns a function removing the secrets of every volume from
+// log output: at debug level the logs hold mount commands with metaurls and
+// tokens, and the options given at Create. Secret references are resolved,
+// so that their values are removed too.
+func (d *jfsDriver) logSanitizer() func(string) string {
+	d.RLock()
+	vols := make([]*jfsVolume, 0, len(d.volumes))
+	for _, v := range d.volumes {
+		vols = append(vols, &jfsVolume{Name: v.Name, Options: maps.Clone(v.Options), Source: v.Source, Mountpoint: v.Mountpoint})
+	}
+	d.RUnlock()
+
+	var secrets []string
+	var metaURLs []string
+	for _, v := range vols {
+		opts, err := mountOptions(v)
+		if err != nil {
+			opts = v.Options
+		}
+		secrets = append(secrets, volumeSecrets(v, opts)...)
+		secrets = append(secrets, secretValues(v.Options)...)
+		if isCommunityEdition(v) {
+			metaURLs = append(metaURLs, v.Source, redactMetaURL(v.Source))
+		}
+	}
+	replaceMetaURLs := strings.NewReplacer(metaURLs...)
+	return func(s string) string {
+		return sanitizeOutput(replaceMetaURLs.Replace(s), secrets)
+	}
+}
+
+// sanitizedState returns the driver state as JSON with secrets redacted.
+func (d *jfsDriver) sanitizedState() ([]byte, error) {
+	d.RLock()
+	defer d.RUnlock()
+	state := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		state[name] = &jfsVolume{
+			Name:       v.Name,
+			Options:    redactOptions(v.Options),
+			Source:     redactURL(v.Source),
+			Mountpoint: v.Mountpoint,
+			Quarantine: v.quarantine.Load(),
+			Client:     v.client.Load(),
+			LastError:  v.lastError.Load(),
+		}
+	}
+	return json.MarshalIndent(state, "", "  ")
+}
+
+func cliVersions() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, getBuildInfo())
+	for _, path := range []string{ceCliPath, eeCliPath} {
+		out, err := exec.Command(path, "version").CombinedOutput()
+		fmt.Fprintf(&buf, "%s version: %s", path, bytes.TrimSpace(out))
+		if err != nil {
+			fmt.Fprintf(&buf, " (%s)", err)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// juicefsMountinfo returns the mountinfo entries of JuiceFS mounts and of
+// anything mounted below the volumes root.
+func juicefsMountinfo(root string) []byte {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return []byte(err.Error())
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "juicefs") || strings.Contains(line, root) {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func tailFile(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > n {
+		if _, err := f.Seek(fi.Size()-n, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
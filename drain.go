@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// drainTimeout returns the per-volume `drain-timeout` option, falling back
+// to the DRAIN_TIMEOUT driver setting. Zero disables draining.
+func drainTimeout(v *jfsVolume) time.Duration {
+	if val, ok := v.Options["drain-timeout"]; ok {
+		if d, err := parseDuration(val); err == nil {
+			return d
+		}
+		mountLog.Warnf("ignoring invalid drain-timeout %q for volume %s", val, v.Name)
+	}
+	return envDuration("DRAIN_TIMEOUT", 0)
+}
+
+// lazyUnmount reports whether a failed drain or a busy mount may escalate to
+// `umount -l`.
+func lazyUnmount(v *jfsVolume) bool {
+	if val, ok := v.Options["lazy-unmount"]; ok {
+		return isFlagEnabled(val)
+	}
+	return envBool("LAZY_UNMOUNT", false)
+}
+
+// drainVolume waits until the client has no open file handles and no dirty
+// buffered data, logging progress every few seconds. It returns a
+// descriptive error if the volume is still busy after the drain timeout.
+func drainVolume(v *jfsVolume, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	lastReport := time.Time{}
+	for {
+		stats, err := readMountStats(v.Mountpoint)
+		if err != nil {
+			mountLog.Warnf("cannot read stats of %s, not draining: %s", v.Mountpoint, err)
+			return nil
+		}
+		handles := stats["juicefs_fuse_open_handlers"]
+		dirty := stats["juicefs_used_buffer_size_bytes"]
+		if handles == 0 && dirty == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("volume %s still busy after draining for %s: %g open file handles, %g bytes of dirty data", v.Name, timeout, handles, dirty)
+		}
+		if time.Since(lastReport) >= 5*time.Second {
+			volumeLog(mountLog, v).Infof("draining volume %s: %g open file handles, %g bytes of dirty data, %s left",
+				v.Name, handles, dirty, time.Until(deadline).Round(time.Second))
+			lastReport = time.Now()
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// lazyUmountVolume detaches the mount immediately and lets the kernel clean
+// it up once the remaining users are gone.
+func lazyUmountVolume(v *jfsVolume) error {
+	stopAccessLog(v)
+	cmd := exec.Command("umount", "-l", v.Mountpoint)
+	volumeLog(mountLog, v).Debug(cmd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		volumeLog(mountLog, v).Errorf("juicefs lazy umount error: %s", out)
+		return logError("%s", err)
+	}
+	v.client.Store(nil)
+	return nil
+}
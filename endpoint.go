@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bucketNameRe matches S3 bucket names: 3 to 63 lowercase letters, digits,
+// dots and hyphens, starting and ending with a letter or digit.
+var bucketNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// checkBucketURL validates the bucket URL of an S3 compatible storage,
+// which JuiceFS otherwise rejects only at format time or, worse, accepts
+// and sends to the wrong host. Path-style URLs name the bucket in the path
+// (http://minio:9000/mybucket); virtual-hosted ones in the host
+// (https://mybucket.s3.us-east-1.amazonaws.com). MinIO always uses path
+// style; `path-style` states which one an S3 endpoint is expected to use.
+func checkBucketURL(storage, bucket string, pathStyle *bool) error {
+	const examples = "e.g. http://minio:9000/mybucket or https://mybucket.s3.us-east-1.amazonaws.com"
+	u, err := url.Parse(bucket)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid bucket %q: expected an http(s) URL, %s", bucket, examples)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid bucket %q: scheme must be http or https, not %s", bucket, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("invalid bucket %q: give credentials with access-key and secret-key, not in the URL", redactURL(bucket))
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid bucket %q: unexpected query or fragment", bucket)
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid bucket %q: invalid port %s", bucket, port)
+		}
+	}
+
+	path := strings.Trim(u.Path, "/")
+	if strings.Contains(path, "/") {
+		return fmt.Errorf("invalid bucket %q: the path must be just the bucket name", bucket)
+	}
+	wantPath := storage == "minio"
+	if pathStyle != nil {
+		if !*pathStyle && storage == "minio" {
+			return fmt.Errorf("path-style=false is not supported by minio storage")
+		}
+		wantPath = *pathStyle
+	}
+	switch {
+	case wantPath && path == "":
+		return fmt.Errorf("invalid bucket %q: a path-style URL must end with the bucket name, e.g. %s://%s/mybucket", bucket, u.Scheme, u.Host)
+	case pathStyle != nil && !wantPath && path != "":
+		return fmt.Errorf("invalid bucket %q: a virtual-hosted URL names the bucket in the host, e.g. %s://%s.%s", bucket, u.Scheme, path, u.Hostname())
+	}
+	name := path
+	if name == "" {
+		name, _, _ = strings.Cut(u.Hostname(), ".")
+	}
+	if !bucketNameRe.MatchString(name) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid bucket %q: %q is not a valid bucket name", bucket, name)
+	}
+	return nil
+}
+
+// checkEndpointReachable dials the host of a bucket URL.
+func checkEndpointReachable(u *url.URL) error {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), preflightTimeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
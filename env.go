@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// optionEnv returns the process environment extended with the variables
+// the storage options need and those requested by the volume's `env`
+// (comma separated KEY=VALUE pairs) and `env-file` (one KEY=VALUE per line)
+// options.
+func optionEnv(opts map[string]string) ([]string, error) {
+	env := os.Environ()
+	vars, err := storageEnv(opts)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, vars...)
+	if val := opts["env"]; val != "" {
+		env = append(env, strings.Split(val, ",")...)
+	}
+	if path := opts["env-file"]; path != "" {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, vars...)
+	}
+	return env, nil
+}
+
+// readEnvFile parses a file of KEY=VALUE lines. Blank lines and lines
+// starting with # are ignored, an optional "export " prefix is accepted and
+// values may be wrapped in single or double quotes.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env-file: %w", err)
+	}
+	defer f.Close()
+
+	var vars []string
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("env-file %s:%d: expected KEY=VALUE", path, lineno)
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		vars = append(vars, key+"="+val)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("env-file %s: %w", path, err)
+	}
+	return vars, nil
+}
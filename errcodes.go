@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Error codes prefix the errors returned to Docker as "[CODE] message", so
+// that scripts can branch on the class of a failure instead of its wording.
+// Codes are stable; messages are not.
+const (
+	codeStarting          = "JFS_STARTING"
+	codeVolumeNotFound    = "JFS_VOLUME_NOT_FOUND"
+	codeVolumeInUse       = "JFS_VOLUME_IN_USE"
+	codeInvalidOption     = "JFS_INVALID_OPTION"
+	codePolicyDenied      = "JFS_POLICY_DENIED"
+	codeSecretUnresolved  = "JFS_SECRET_UNRESOLVED"
+	codeMetaUnreachable   = "JFS_META_UNREACHABLE"
+	codeBucketUnreachable = "JFS_BUCKET_UNREACHABLE"
+	codeAuthFailed        = "JFS_AUTH_FAILED"
+	codeFormatFailed      = "JFS_FORMAT_FAILED"
+	codeMountTimeout      = "JFS_MOUNT_TIMEOUT"
+	codeMountLimit        = "JFS_MOUNT_LIMIT"
+	codeQuarantined       = "JFS_QUARANTINED"
+
+	// Fallbacks for errors without a more specific code.
+	codeCreateFailed  = "JFS_CREATE_FAILED"
+	codeMountFailed   = "JFS_MOUNT_FAILED"
+	codeUnmountFailed = "JFS_UNMOUNT_FAILED"
+	codeRemoveFailed  = "JFS_REMOVE_FAILED"
+	codeInternal      = "JFS_INTERNAL"
+)
+
+var errorCodeRe = regexp.MustCompile(`\[(JFS_[A-Z_]+)\] `)
+
+// codedError is logError for errors with a code.
+func codedError(code, format string, args ...interface{}) error {
+	return logError("[%s] "+format, append([]interface{}{code}, args...)...)
+}
+
+// withCode gives err the code unless it already carries one.
+func withCode(code string, err error) error {
+	if err == nil || errorCodeRe.MatchString(err.Error()) {
+		return err
+	}
+	return fmt.Errorf("[%s] %w", code, err)
+}
+
+// apiError prepares an error for Docker: a code given deeper down, e.g.
+// "failed to mount v: [JFS_AUTH_FAILED] ...", is moved to the front, and
+// errors without one get the fallback code.
+func apiError(fallback string, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	m := errorCodeRe.FindStringSubmatchIndex(msg)
+	switch {
+	case m == nil:
+		return fmt.Errorf("[%s] %s", fallback, msg)
+	case m[0] == 0:
+		return err
+	default:
+		return fmt.Errorf("[%s] %s%s", msg[m[2]:m[3]], msg[:m[0]], msg[m[1]:])
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "sync"
+
+// eventHistory keeps the last EVENT_HISTORY (default 20) events of every
+// volume, a short timeline for debugging intermittent issues that works with
+// every state backend. It has its own lock as events are emitted with and
+// without d's lock held.
+var eventHistory = struct {
+	sync.Mutex
+	byVolume map[string][]event
+}{byVolume: map[string][]event{}}
+
+func init() {
+	addEventSink(recordVolumeEvent)
+}
+
+func eventHistorySize() int {
+	return envInt("EVENT_HISTORY", 20)
+}
+
+// recordVolumeEvent adds an event to the history of its volume. The history
+// of a removed volume is dropped.
+func recordVolumeEvent(e event) {
+	size := eventHistorySize()
+	eventHistory.Lock()
+	defer eventHistory.Unlock()
+	if e.Type == "removed" || size <= 0 {
+		delete(eventHistory.byVolume, e.Volume)
+		return
+	}
+	list := append(eventHistory.byVolume[e.Volume], e)
+	if len(list) > size {
+		list = list[len(list)-size:]
+	}
+	eventHistory.byVolume[e.Volume] = list
+}
+
+// volumeEvents returns the recorded events of a volume, oldest first.
+func volumeEvents(name string) []event {
+	eventHistory.Lock()
+	defer eventHistory.Unlock()
+	return append([]event{}, eventHistory.byVolume[name]...)
+}
+
+// loadEventHistory restores the histories saved with the volumes.
+func loadEventHistory(volumes map[string]*jfsVolume) {
+	eventHistory.Lock()
+	defer eventHistory.Unlock()
+	for name, v := range volumes {
+		if len(v.Events) > 0 {
+			eventHistory.byVolume[name] = v.Events
+		}
+	}
+}
+
+// storeEventHistory copies the histories into the volumes to be saved with
+// them. The caller holds d's lock.
+func storeEventHistory(volumes map[string]*jfsVolume) {
+	eventHistory.Lock()
+	defer eventHistory.Unlock()
+	for name, v := range volumes {
+		v.Events = append([]event(nil), eventHistory.byVolume[name]...)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// event is a volume lifecycle notification delivered to WEBHOOK_URL.
+type event struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	Volume  string                 `json:"volume"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// eventSinks receive every event, in addition to the webhook. They are
+// added at startup and must not block.
+var eventSinks []func(event)
+
+// addEventSink registers fn to receive all events.
+func addEventSink(fn func(event)) {
+	eventSinks = append(eventSinks, fn)
+}
+
+// emitEvent passes the event to the event sinks and posts it as JSON to the
+// lifecycle webhook, if one is configured. Delivery is asynchronous and best
+// effort: failures are logged and never affect the volume operation that
+// triggered the event.
+func emitEvent(typ, volume, message string, details map[string]interface{}) {
+	e := event{Time: time.Now().UTC(), Type: typ, Volume: volume, Message: message, Details: details}
+	for _, sink := range eventSinks {
+		sink(e)
+	}
+	url := os.Getenv("WEBHOOK_URL")
+	if url == "" {
+		return
+	}
+	go func() {
+		data, err := json.Marshal(e)
+		if err != nil {
+			logrus.WithField("event", typ).Error(err)
+			return
+		}
+		resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			logrus.WithField("event", typ).Warnf("webhook delivery failed: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logrus.WithField("event", typ).Warnf("webhook returned %s", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stateExportVersion is the version of the state export format.
+const stateExportVersion = 1
+
+// stateExport is the portable form of the driver state, for moving volume
+// definitions to another node. It holds definitions only: mount IDs,
+// client processes and quarantines belong to the node that exported them.
+type stateExport struct {
+	Version    int                       `json:"version"`
+	ExportedAt time.Time                 `json:"exported_at"`
+	Volumes    map[string]exportedVolume `json:"volumes"`
+}
+
+type exportedVolume struct {
+	Name    string            `json:"name"`
+	Source  string            `json:"source"`
+	Options map[string]string `json:"options"`
+}
+
+// importResult reports which volumes an import added and which it left
+// alone because a volume of the same name exists.
+type importResult struct {
+	Imported []string `json:"imported"`
+	Skipped  []string `json:"skipped"`
+}
+
+// exportState returns the volume definitions. With stripSecrets, options
+// holding literal secrets are left out; secret references are kept, as
+// they resolve on the importing node too.
+func (d *jfsDriver) exportState(stripSecrets bool) *stateExport {
+	d.RLock()
+	defer d.RUnlock()
+
+	export := &stateExport{Version: stateExportVersion, ExportedAt: time.Now().UTC(), Volumes: map[string]exportedVolume{}}
+	for name, v := range d.volumes {
+		opts := map[string]string{}
+		for k, val := range v.Options {
+			if stripSecrets && isSecretOption(k) && !isSecretRef(val) {
+				continue
+			}
+			opts[k] = val
+		}
+		export.Volumes[name] = exportedVolume{Name: v.Name, Source: v.Source, Options: opts}
+	}
+	return export
+}
+
+// importState adds the volumes of an export that do not exist yet. They go
+// through the same admission checks as volumes created with Docker, except
+// for the connectivity checks, and are not mounted; Docker mounts them on
+// demand as usual. Nothing is imported if any volume is rejected.
+func (d *jfsDriver) importState(export *stateExport) (*importResult, error) {
+	if export.Version != stateExportVersion {
+		return nil, fmt.Errorf("unsupported state export version %d", export.Version)
+	}
+	d.Lock()
+	defer d.Unlock()
+
+	result := &importResult{Imported: []string{}, Skipped: []string{}}
+	rollback := func() {
+		for _, name := range result.Imported {
+			delete(d.volumes, name)
+		}
+		if err := d.writeHosts(nil); err != nil {
+			logrus.Warnf("cannot update %s: %s", hostsFile, err)
+		}
+	}
+	for _, name := range sortedKeys(export.Volumes) {
+		ev := export.Volumes[name]
+		if _, ok := d.volumes[name]; ok {
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+		if ev.Name == "" {
+			rollback()
+			return nil, fmt.Errorf("volume %s has no name", name)
+		}
+		options := map[string]string{"name": ev.Name}
+		if ev.Source != "" && ev.Source != ev.Name {
+			options["metaurl"] = ev.Source
+		}
+		for k, val := range ev.Options {
+			options[k] = val
+		}
+		// The options of the source were merged in when the volume was
+		// created; it need not exist here.
+		delete(options, "inherit")
+		v, err := d.newVolume(name, options)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("volume %s: %s", name, err)
+		}
+		d.volumes[name] = v
+		result.Imported = append(result.Imported, name)
+	}
+	if len(result.Imported) > 0 {
+		d.saveState()
+	}
+	for _, name := range result.Imported {
+		emitEvent("created", name, "imported", nil)
+	}
+	return result, nil
+}
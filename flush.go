@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const defaultFlushTimeout = 60 * time.Second
+
+// readMountStats parses the `.stats` virtual file exposed at the root of a
+// JuiceFS mount. Each line is "<metric>[{labels}] <value>".
+func readMountStats(mountpoint string) (map[string]float64, error) {
+	f, err := os.Open(filepath.Join(mountpoint, ".stats"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := map[string]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.LastIndexByte(line, ' ')
+		if i <= 0 {
+			continue
+		}
+		val, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		stats[line[:i]] = val
+	}
+	return stats, scanner.Err()
+}
+
+// syncfs flushes data written through the FUSE mount to the client.
+func syncfs(mountpoint string) error {
+	f, err := os.Open(mountpoint)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.Syncfs(int(f.Fd()))
+}
+
+// flushTimeout returns the per-volume `flush-timeout` option, falling back
+// to the FLUSH_TIMEOUT driver setting.
+func flushTimeout(v *jfsVolume) time.Duration {
+	if val, ok := v.Options["flush-timeout"]; ok {
+		if d, err := parseDuration(val); err == nil {
+			return d
+		}
+		mountLog.Warnf("ignoring invalid flush-timeout %q for volume %s", val, v.Name)
+	}
+	return envDuration("FLUSH_TIMEOUT", defaultFlushTimeout)
+}
+
+// flushVolume waits until a writeback-enabled client has uploaded all staged
+// blocks to object storage, so that a successful Unmount means the data is
+// durable. It gives up after the volume's flush timeout.
+func flushVolume(v *jfsVolume) error {
+	timeout := flushTimeout(v)
+	if err := syncfs(v.Mountpoint); err != nil {
+		mountLog.Warnf("syncfs on %s failed: %s", v.Mountpoint, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		stats, err := readMountStats(v.Mountpoint)
+		if err != nil {
+			// Without stats we cannot tell whether uploads are pending;
+			// fall back to the previous behaviour of a plain umount.
+			mountLog.Warnf("cannot read stats of %s, not waiting for uploads: %s", v.Mountpoint, err)
+			return nil
+		}
+		staging := stats["juicefs_staging_blocks"]
+		if staging == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%g blocks of volume %s still pending upload after %s", staging, v.Name, timeout)
+		}
+		volumeLog(mountLog, v).Debugf("waiting for %g staged blocks of volume %s to upload", staging, v.Name)
+		time.Sleep(time.Second)
+	}
+}
+
+// isWriteback reports whether the volume's client buffers writes locally
+// before uploading them.
+func isWriteback(v *jfsVolume) bool {
+	val, ok := v.Options["writeback"]
+	return ok && isFlagEnabled(val)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// formatJob is a `juicefs format` running in the background, started at
+// Create with `async-format=true` so formatting a large or slow filesystem
+// does not run into Docker's request timeout.
+type formatJob struct {
+	started time.Time
+	done    chan struct{}
+
+	mu       sync.Mutex
+	finished time.Time
+	err      error
+}
+
+// startFormat formats a CE volume in the background. Mount waits for it.
+func startFormat(v *jfsVolume) error {
+	opts, err := mountOptions(v)
+	if err != nil {
+		return logError("failed to expand options of volume %s: %s", v.Name, err)
+	}
+	env, err := optionEnv(opts)
+	if err != nil {
+		return logError("%s", err)
+	}
+	format, _ := ceFormatCommand(v, env, opts)
+	job := &formatJob{started: time.Now(), done: make(chan struct{})}
+	go func() {
+		err := runFormat(v, format, volumeSecrets(v, opts))
+		job.mu.Lock()
+		job.finished = time.Now()
+		job.err = err
+		job.mu.Unlock()
+		close(job.done)
+		if err == nil {
+			volumeLog(mountLog, v).Infof("background format finished in %s", job.finished.Sub(job.started).Round(time.Millisecond))
+		}
+	}()
+	v.format.Store(job)
+	return nil
+}
+
+// wait blocks until the format has finished and returns its error.
+func (j *formatJob) wait() error {
+	<-j.done
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// addStatus reports the progress of the format in a volume's Status.
+func (j *formatJob) addStatus(status map[string]interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case j.finished.IsZero():
+		status["format"] = "running"
+		status["format_elapsed"] = time.Since(j.started).Round(time.Second).String()
+	case j.err != nil:
+		status["format"] = "failed"
+		status["format_error"] = j.err.Error()
+	default:
+		status["format"] = "done"
+		status["format_duration"] = j.finished.Sub(j.started).Round(time.Second).String()
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+func init() {
+	registerSecretResolver("gcp-sm", &gcpSecretManager{pinned: map[string]string{}})
+}
+
+var gcpHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// gcpSecretManager resolves
+// `gcp-sm://projects/PROJECT/secrets/SECRET[/versions/VERSION]` with
+// application default credentials. Without a version the latest one is
+// fetched on every mount, so a remount picks up a new version; pinned
+// versions never change and are fetched once.
+type gcpSecretManager struct {
+	mu     sync.Mutex
+	pinned map[string]string
+}
+
+func (g *gcpSecretManager) Resolve(ref string) (string, error) {
+	name := strings.Trim(ref, "/")
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return "", fmt.Errorf("invalid secret %q, expected projects/PROJECT/secrets/SECRET[/versions/VERSION]", ref)
+	}
+	pinned := strings.Contains(name, "/versions/") && !strings.HasSuffix(name, "/versions/latest")
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	if pinned {
+		g.mu.Lock()
+		value, ok := g.pinned[name]
+		g.mu.Unlock()
+		if ok {
+			return value, nil
+		}
+	}
+
+	token, err := gcpToken()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doJSON(gcpHTTPClient, req, &resp); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	value := string(data)
+	if pinned {
+		g.mu.Lock()
+		g.pinned[name] = value
+		g.mu.Unlock()
+	}
+	return value, nil
+}
+
+var gcpAccessToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// gcpToken returns an OAuth2 access token from the service account key in
+// GOOGLE_APPLICATION_CREDENTIALS or else from the metadata server.
+func gcpToken() (string, error) {
+	gcpAccessToken.Lock()
+	defer gcpAccessToken.Unlock()
+	if time.Now().Before(gcpAccessToken.expires) {
+		return gcpAccessToken.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		req, err = gcpServiceAccountTokenRequest(path)
+	} else {
+		req, err = http.NewRequest(http.MethodGet, gcpMetadataTokenURL, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(gcpHTTPClient, req, &resp); err != nil {
+		return "", fmt.Errorf("no GCP credentials: %s", err)
+	}
+	gcpAccessToken.token = resp.AccessToken
+	gcpAccessToken.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return resp.AccessToken, nil
+}
+
+// gcpServiceAccountTokenRequest builds the JWT bearer token request for a
+// service account key file.
+func gcpServiceAccountTokenRequest(path string) (*http.Request, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("no private key in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is not an RSA key", path)
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/cloud-platform",
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	req, err := http.NewRequest(http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// doJSON sends a request and decodes a JSON response, failing on non-2xx
+// statuses.
+func doJSON(client *http.Client, req *http.Request, output interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, output)
+}
@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// unmountGrace returns the per-volume `unmount-grace` option, falling back
+// to the UNMOUNT_GRACE driver setting. It is how long a volume stays
+// mounted after its last container stops, so a restarting container does
+// not pay for a full mount. Zero unmounts right away.
+func unmountGrace(v *jfsVolume) time.Duration {
+	if val, ok := v.Options["unmount-grace"]; ok {
+		if d, err := parseDuration(val); err == nil {
+			return d
+		}
+		mountLog.Warnf("ignoring invalid unmount-grace %q for volume %s", val, v.Name)
+	}
+	return envDuration("UNMOUNT_GRACE", 0)
+}
+
+// scheduleUnmount tears the volume down after the grace period unless it is
+// mounted again in the meantime. The caller holds v.mu.
+func scheduleUnmount(name string, v *jfsVolume, grace time.Duration) {
+	volumeLog(mountLog, v).Debugf("unmounting in %s", grace)
+	var timer *time.Timer
+	timer = time.AfterFunc(grace, func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if v.unmountTimer != timer || v.connectionCount() > 0 {
+			return
+		}
+		v.unmountTimer = nil
+		if err := teardownVolume(name, v); err != nil {
+			volumeLog(mountLog, v).Warn(err)
+		}
+	})
+	v.unmountTimer = timer
+}
+
+// cancelScheduledUnmount keeps the volume mounted. The caller holds v.mu.
+func cancelScheduledUnmount(v *jfsVolume) {
+	if v.unmountTimer != nil {
+		v.unmountTimer.Stop()
+		v.unmountTimer = nil
+	}
+}
+
+// unmountScheduled tears down a volume waiting for its grace period right
+// away. The caller holds v.mu.
+func unmountScheduled(name string, v *jfsVolume) error {
+	if v.unmountTimer == nil {
+		return nil
+	}
+	cancelScheduledUnmount(v)
+	return teardownVolume(name, v)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// leaderLock holds the leadership lock; closing it, or letting it be
+// garbage collected, would release the lock.
+var leaderLock *os.File
+
+// acquireLeadership takes the leadership lock at path, an exclusive flock
+// shared by the plugin instances of an active-standby pair. While another
+// instance holds it, this one is the standby and blocks until the leader
+// exits; only then does it load the state, adopt the leader's mounts and
+// serve the socket. The lock is held for the life of the process.
+func acquireLeadership(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		holder, _ := os.ReadFile(path)
+		logrus.Infof("standing by, leadership lock %s is held by %s", path, holder)
+		sdNotify("STATUS=standby")
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot lock %s: %s", path, err)
+	}
+	hostname, _ := os.Hostname()
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "pid %d on %s", os.Getpid(), hostname)
+	}
+	logrus.Infof("acquired leadership lock %s", path)
+	leaderLock = f
+	return nil
+}
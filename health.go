@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// volumeHealth is the liveness of the client serving a mounted volume.
+type volumeHealth struct {
+	ClientAlive bool   `json:"client_alive"`
+	StatfsOK    bool   `json:"statfs_ok"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (h volumeHealth) healthy() bool {
+	return h.ClientAlive && h.StatfsOK
+}
+
+// statfsTimeout returns the HEALTH_STATFS_TIMEOUT driver setting, how long
+// statfs on a mountpoint may take before the mount counts as hung.
+func statfsTimeout() time.Duration {
+	return envDuration("HEALTH_STATFS_TIMEOUT", 5*time.Second)
+}
+
+// checkVolumeHealth checks that the client process of a mounted volume is
+// running and that the mount answers statfs in time. A statfs stuck in a
+// hung mount leaves its goroutine behind.
+func checkVolumeHealth(v *jfsVolume, timeout time.Duration) volumeHealth {
+	h := volumeHealth{}
+	if c := v.client.Load(); c != nil {
+		h.ClientAlive = c.alive()
+	} else {
+		h.ClientAlive = findMountProcess(v.Mountpoint) != 0
+	}
+	done := make(chan error, 1)
+	go func() {
+		var st syscall.Statfs_t
+		done <- syscall.Statfs(v.Mountpoint, &st)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			h.Error = fmt.Sprintf("statfs: %s", err)
+		} else {
+			h.StatfsOK = true
+		}
+	case <-time.After(timeout):
+		h.Error = fmt.Sprintf("statfs did not answer within %s", timeout)
+	}
+	if !h.ClientAlive && h.Error == "" {
+		h.Error = "no juicefs client process"
+	}
+	return h
+}
+
+// health checks every volume in use on the node.
+func (d *jfsDriver) health() (bool, map[string]volumeHealth) {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		if v.connectionCount() > 0 {
+			vols[name] = v
+		}
+	}
+	d.RUnlock()
+
+	timeout := statfsTimeout()
+	ok := true
+	report := map[string]volumeHealth{}
+	for name, v := range vols {
+		h := checkVolumeHealth(v, timeout)
+		report[name] = h
+		if !h.healthy() {
+			ok = false
+		}
+	}
+	return ok, report
+}
+
+// healthz handles
+//
+//	GET /healthz
+//
+// with 200 if every volume in use is served by a live client and the
+// self-test, if configured, did not fail, and 503 otherwise.
+func (d *jfsDriver) healthz(w http.ResponseWriter, r *http.Request) {
+	ok, report := d.health()
+	resp := map[string]interface{}{"volumes": report}
+	if st := selftestStatus(); st != nil {
+		resp["selftest"] = st
+		ok = ok && st.Status != "failed"
+	}
+	status, code := "ok", http.StatusOK
+	if !ok {
+		status, code = "unhealthy", http.StatusServiceUnavailable
+	}
+	resp["status"] = status
+	writeAdminJSON(w, code, resp)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// hostsFile is the plugin's hosts file, which the juicefs clients and the
+// preflight checks resolve names with before asking DNS.
+const hostsFile = "/etc/hosts"
+
+const (
+	hostsBegin = "# BEGIN docker-volume-juicefs resolve entries"
+	hostsEnd   = "# END docker-volume-juicefs resolve entries"
+)
+
+// parseResolve parses the `resolve` option, comma separated HOST:IP pairs
+// such as "redis.internal:10.0.0.5,minio.internal:2001:db8::7".
+func parseResolve(val string) (map[string]string, error) {
+	entries := map[string]string{}
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, ip, ok := strings.Cut(entry, ":")
+		ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+		if !ok || host == "" || strings.ContainsAny(host, " \t#") || net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid resolve entry %q: expected HOST:IP", entry)
+		}
+		entries[strings.ToLower(host)] = ip
+	}
+	return entries, nil
+}
+
+// writeHosts rewrites the block of the hosts file managed by the plugin
+// with the `resolve` entries of all volumes and of v, a volume being
+// created. Volumes must agree on the address of a host. The caller holds
+// d's lock.
+func (d *jfsDriver) writeHosts(v *jfsVolume) error {
+	vols := []*jfsVolume{}
+	for _, name := range sortedKeys(d.volumes) {
+		vols = append(vols, d.volumes[name])
+	}
+	if v != nil {
+		vols = append(vols, v)
+	}
+	hosts := map[string]string{}
+	for _, vol := range vols {
+		val, ok := vol.Options["resolve"]
+		if !ok {
+			continue
+		}
+		entries, err := parseResolve(val)
+		if err != nil {
+			return err
+		}
+		for host, ip := range entries {
+			if prev, ok := hosts[host]; ok && prev != ip {
+				return fmt.Errorf("resolve entry %s:%s of volume %s conflicts with %s:%s of another volume", host, ip, vol.Name, host, prev)
+			}
+			hosts[host] = ip
+		}
+	}
+
+	data, err := os.ReadFile(hostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(data)
+	if len(hosts) == 0 && !strings.Contains(content, hostsBegin) {
+		return nil
+	}
+	var kept []string
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		switch {
+		case line == hostsBegin:
+			inBlock = true
+		case line == hostsEnd:
+			inBlock = false
+		case !inBlock && (line != "" || len(kept) > 0):
+			kept = append(kept, line)
+		}
+	}
+	if len(hosts) > 0 {
+		kept = append(kept, hostsBegin)
+		for _, host := range sortedKeys(hosts) {
+			kept = append(kept, hosts[host]+"\t"+host)
+		}
+		kept = append(kept, hostsEnd)
+	}
+	updated := strings.Join(kept, "\n") + "\n"
+	if updated == content {
+		return nil
+	}
+	// /etc/hosts is usually bind mounted, so it is rewritten in place
+	// rather than replaced.
+	return os.WriteFile(hostsFile, []byte(updated), 0644)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// idleTimeout returns the per-volume `idle-unmount` option, falling back to
+// the IDLE_UNMOUNT driver setting. Volumes mounted without any container
+// for this long are unmounted. Zero disables idle unmounts.
+func idleTimeout(v *jfsVolume) time.Duration {
+	if val, ok := v.Options["keep-mounted"]; ok && isFlagEnabled(val) {
+		return 0
+	}
+	if val, ok := v.Options["idle-unmount"]; ok {
+		if d, err := parseDuration(val); err == nil {
+			return d
+		}
+		monitorLog.Warnf("ignoring invalid idle-unmount %q for volume %s", val, v.Name)
+	}
+	return envDuration("IDLE_UNMOUNT", 0)
+}
+
+// unmountIdle is the periodic job that reclaims the clients of volumes no
+// container has used for their idle timeout, including premounted ones.
+func (d *jfsDriver) unmountIdle() error {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		vols[name] = v
+	}
+	d.RUnlock()
+
+	var failed []string
+	for name, v := range vols {
+		if err := unmountIfIdle(name, v); err != nil {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to unmount idle volumes %v", failed)
+	}
+	return nil
+}
+
+func unmountIfIdle(name string, v *jfsVolume) error {
+	timeout := idleTimeout(v)
+	if timeout <= 0 {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Containers are counted by their mounts, which survive restarts of
+	// the plugin, and a mount they use is never taken down.
+	if len(v.MountIDs) > 0 {
+		return nil
+	}
+	if v.lastUsed.IsZero() {
+		// Mounted before the plugin started; start counting now.
+		v.lastUsed = time.Now()
+		return nil
+	}
+	idle := time.Since(v.lastUsed)
+	if idle < timeout || !isJuiceFSMountedRoot(v.Mountpoint) {
+		return nil
+	}
+
+	monitorLog.WithField("volume", name).Infof("unmounting after %s without containers", idle.Round(time.Second))
+	cancelScheduledUnmount(v)
+	if err := teardownVolume(name, v); err != nil {
+		return err
+	}
+	// Only the premount connection was left.
+	v.pinned = false
+	v.connections.Store(0)
+	return nil
+}
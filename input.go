@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the options of a volume. Option keys and values end up in
+// juicefs command lines, environment variables and log lines, so they are
+// bounded and restricted to printable text before anything else looks at
+// them.
+const (
+	maxOptions        = 128
+	maxOptionKeyLen   = 128
+	maxOptionValueLen = 16 << 10
+)
+
+var optionKeyRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// volumeNameRe is the rule Docker applies to volume names. Names also
+// become the mountpoint below the volume root.
+var volumeNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]+$`)
+
+// checkVolumeName rejects names Docker would not accept.
+func checkVolumeName(name string) error {
+	if !volumeNameRe.MatchString(name) {
+		return fmt.Errorf("invalid volume name %.64q: must be letters, digits, '_', '.' or '-', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// checkOptionInput enforces the option limits. Values must be valid UTF-8
+// without control characters; secrets such as inline keyrings and
+// credential files may also contain newlines and tabs.
+func checkOptionInput(options map[string]string) error {
+	if len(options) > maxOptions {
+		return fmt.Errorf("too many options: %d, at most %d are allowed", len(options), maxOptions)
+	}
+	for k, val := range options {
+		if len(k) > maxOptionKeyLen || !optionKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid option name %.64q: must be at most %d letters, digits, '.', '_', '/' or '-'", k, maxOptionKeyLen)
+		}
+		if len(val) > maxOptionValueLen {
+			return fmt.Errorf("value of option %s is too long: %d bytes, at most %d are allowed", k, len(val), maxOptionValueLen)
+		}
+		if !utf8.ValidString(val) {
+			return fmt.Errorf("value of option %s is not valid UTF-8", k)
+		}
+		multiline := isSecretOption(k)
+		for _, r := range val {
+			if r == '\n' || r == '\t' || r == '\r' {
+				if multiline {
+					continue
+				}
+			} else if unicode.IsPrint(r) || r == ' ' {
+				continue
+			}
+			return fmt.Errorf("value of option %s contains the control character %U", k, r)
+		}
+	}
+	return nil
+}
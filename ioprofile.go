@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// maxProfileDuration bounds how long the profile admin action records the
+// access log of a volume.
+const maxProfileDuration = 5 * time.Minute
+
+// profileVolume records the access log of a mounted volume for duration
+// and returns the report of `juicefs profile` on the recording: the
+// operations by count and total and average latency.
+func (d *jfsDriver) profileVolume(name string, duration time.Duration) ([]byte, error) {
+	d.RLock()
+	v, ok := d.volumes[name]
+	d.RUnlock()
+	if !ok {
+		return nil, codedError(codeVolumeNotFound, "volume %s not found", name)
+	}
+	if !isJuiceFSMountedRoot(v.Mountpoint) {
+		return nil, fmt.Errorf("volume %s is not mounted on this node", name)
+	}
+
+	accessLog, err := recordAccessLog(filepath.Join(v.Mountpoint, ".accesslog"), duration)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the access log of volume %s: %s", name, err)
+	}
+	f, err := os.CreateTemp("", "jfs-accesslog-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(accessLog)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cli := eeCliPath
+	if isCommunityEdition(v) {
+		cli = ceCliPath
+	}
+	// With --interval 0 a recorded log is replayed and summarized once.
+	profile := exec.Command(cli, "profile", f.Name(), "--interval", "0")
+	cliLog.Debug(profile)
+	out, err := profile.CombinedOutput()
+	recordOutput(v, profile, out, err, nil)
+	if err != nil {
+		return nil, fmt.Errorf("juicefs profile failed for volume %s: %s", name, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// recordAccessLog reads the access log of a mount for duration. Reads of
+// the access log block while the mount is idle, so the reader is left
+// behind once the time is up and stops at its next read.
+func recordAccessLog(path string, duration time.Duration) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		defer f.Close()
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := f.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- bytes.Clone(buf[:n]):
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				close(chunks)
+				return
+			}
+		}
+	}()
+
+	var recorded []byte
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return recorded, nil
+			}
+			recorded = append(recorded, chunk...)
+		case <-timer.C:
+			close(done)
+			return recorded, nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobStatus records the outcome of a periodic background job.
+type jobStatus struct {
+	Name      string        `json:"name"`
+	Interval  time.Duration `json:"interval"`
+	Runs      int           `json:"runs"`
+	LastRun   time.Time     `json:"last_run"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+var jobs = struct {
+	sync.Mutex
+	status map[string]*jobStatus
+}{status: map[string]*jobStatus{}}
+
+// schedule runs fn every interval in the background. Errors are logged and
+// recorded; they never stop the job. A non-positive interval disables it.
+func schedule(name string, interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		monitorLog.Debugf("job %s disabled", name)
+		return
+	}
+	jobs.Lock()
+	jobs.status[name] = &jobStatus{Name: name, Interval: interval}
+	jobs.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := fn()
+			jobs.Lock()
+			s := jobs.status[name]
+			s.Runs++
+			s.LastRun = time.Now()
+			s.LastError = ""
+			if err != nil {
+				s.LastError = err.Error()
+			}
+			jobs.Unlock()
+			if err != nil {
+				monitorLog.WithField("job", name).Error(err)
+			}
+		}
+	}()
+}
+
+// jobStatuses returns a snapshot of all scheduled jobs sorted by name.
+func jobStatuses() []jobStatus {
+	jobs.Lock()
+	defer jobs.Unlock()
+	var list []jobStatus
+	for _, s := range jobs.status {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
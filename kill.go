@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// killTimeout returns the KILL_TIMEOUT driver setting, how long a client
+// gets to exit after SIGTERM before it is sent SIGKILL. Zero disables
+// killing clients.
+func killTimeout() time.Duration {
+	return envDuration("KILL_TIMEOUT", 10*time.Second)
+}
+
+// isWedged reports whether the client of a mounted volume no longer serves
+// it: the mountpoint is not connected or statfs fails or hangs. A busy but
+// working mount is not wedged.
+func isWedged(v *jfsVolume) bool {
+	if _, err := os.Stat(v.Mountpoint); errors.Is(err, syscall.ENOTCONN) {
+		return true
+	}
+	return !checkVolumeHealth(v, statfsTimeout()).StatfsOK
+}
+
+// forceUmountVolume is the last resort when umount failed and the client is
+// wedged, or an operator forces the unmount: the mount is unmounted with
+// `juicefs umount --force` or else detached lazily and, if its client is
+// still running, the client is sent SIGTERM and then SIGKILL, so a wedged
+// client cannot block the volume forever.
+func forceUmountVolume(name string, v *jfsVolume) error {
+	client := v.client.Load()
+	if client == nil {
+		if pid := findMountProcess(v.Mountpoint); pid != 0 {
+			if start, err := processStartTime(pid); err == nil {
+				client = &clientProcess{PID: pid, StartTime: start}
+			}
+		}
+	}
+
+	var lazyErr error
+	if _, err := juicefsUmount(v, true); err != nil {
+		lazyErr = lazyUmountVolume(v)
+	}
+	timeout := killTimeout()
+	if client == nil || timeout <= 0 {
+		return lazyErr
+	}
+	if client.alive() {
+		if err := terminateClient(client, timeout); err != nil {
+			return err
+		}
+		volumeLog(mountLog, v).Warnf("killed client %d", client.PID)
+	}
+	v.client.Store(nil)
+	if isJuiceFSMountedRoot(v.Mountpoint) {
+		return lazyUmountVolume(v)
+	}
+	return nil
+}
+
+// terminateClient sends SIGTERM, then SIGKILL once timeout has passed, and
+// waits for the client to exit.
+func terminateClient(c *clientProcess, timeout time.Duration) error {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		if !c.alive() {
+			return nil
+		}
+		mountLog.Infof("sending %s to client %d", sig, c.PID)
+		if err := syscall.Kill(c.PID, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to signal client %d: %s", c.PID, err)
+		}
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			if !c.alive() {
+				return nil
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return fmt.Errorf("client %d did not exit after SIGKILL", c.PID)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// volumeError is the last failed operation of a volume, kept in the state so
+// that `docker volume inspect` still explains it after the API call that
+// failed is long gone.
+type volumeError struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	Error string    `json:"error"`
+}
+
+// recordedErrorOps are the operations whose failures are recorded.
+var recordedErrorOps = []string{"format", "mount", "unmount"}
+
+// recordError remembers err as the last error of v. Secrets given as option
+// values or resolved from references, and the password of the meta URL, are
+// removed.
+func recordError(v *jfsVolume, op string, err error) {
+	opts, rerr := mountOptions(v)
+	if rerr != nil {
+		opts = v.Options
+	}
+	msg := sanitizeOutput(err.Error(), volumeSecrets(v, opts))
+	if redacted := redactURL(v.Source); redacted != v.Source {
+		msg = strings.ReplaceAll(msg, v.Source, redacted)
+	}
+	v.lastError.Store(&volumeError{Time: time.Now().UTC(), Op: op, Error: msg})
+}
+
+// clearError forgets the last error of v once it mounted successfully.
+func clearError(v *jfsVolume) {
+	v.lastError.Store(nil)
+}
+
+// loadLastErrors restores the last errors saved with the volumes.
+func loadLastErrors(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.lastError.Store(v.LastError)
+	}
+}
+
+// storeLastErrors copies the last errors into the volumes to be saved with
+// them. The caller holds d's lock.
+func storeLastErrors(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.LastError = v.lastError.Load()
+	}
+}
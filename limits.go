@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// maxMounts returns the MAX_MOUNTS driver setting, the number of volumes
+// that may be mounted on the node at the same time. Every mount runs its
+// own client, so an unbounded number of them can exhaust the memory of the
+// plugin. Zero means no limit.
+func maxMounts() int {
+	return envInt("MAX_MOUNTS", 0)
+}
+
+// mountedVolumes counts the volumes mounted on the node. The caller holds
+// d's lock.
+func (d *jfsDriver) mountedVolumes() int {
+	n := 0
+	for _, v := range d.volumes {
+		if v.connectionCount() > 0 || isJuiceFSMountedRoot(v.Mountpoint) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkMountLimit fails if mounting one more volume would exceed
+// MAX_MOUNTS, given the number of volumes mounted on the node.
+func checkMountLimit(name string, mounted int) error {
+	limit := maxMounts()
+	if limit > 0 && mounted >= limit {
+		return fmt.Errorf("cannot mount %s: resource exhausted, %d of MAX_MOUNTS=%d volumes are mounted on this node", name, mounted, limit)
+	}
+	return nil
+}
+
+// addMountLimit reports the node's mount count and limit in a volume's
+// Status. The caller holds d's lock.
+func (d *jfsDriver) addMountLimit(status map[string]interface{}) {
+	if limit := maxMounts(); limit > 0 {
+		status["node_mounts"] = d.mountedVolumes()
+		status["node_mount_limit"] = limit
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "strings"
+
+// labelPrefix marks volume options that only label the volume, e.g.
+// `label.team=data`. Labels are not passed to the juicefs CLI.
+const labelPrefix = "label."
+
+// listFilter selects the volumes reported by List. An empty filter matches
+// every volume.
+type listFilter struct {
+	// Prefixes, if set, limit List to volumes whose name starts with one
+	// of them.
+	Prefixes []string `json:"prefixes"`
+	// Labels, if set, limit List to volumes carrying all of them.
+	Labels map[string]string `json:"labels"`
+}
+
+func (f *listFilter) matches(name string, v *jfsVolume) bool {
+	if len(f.Prefixes) > 0 {
+		matched := false
+		for _, prefix := range f.Prefixes {
+			if strings.HasPrefix(name, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for k, val := range f.Labels {
+		if label, ok := v.Options[labelPrefix+k]; !ok || label != val {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLogDir        = "/jfs/state/logs"
+	defaultLogMaxSize    = "10M"
+	defaultLogMaxBackups = 5
+	defaultLogMaxAge     = 7 * 24 * time.Hour
+)
+
+// logRetention bounds the size and age of log files in LOG_DIR.
+type logRetention struct {
+	maxSize    uint64
+	maxBackups int
+	maxAge     time.Duration
+}
+
+func logRetentionFromEnv() logRetention {
+	size, err := parseSize(envString("LOG_MAX_SIZE", defaultLogMaxSize))
+	if err != nil {
+		logrus.Warnf("ignoring invalid LOG_MAX_SIZE: %s", err)
+		size, _ = parseSize(defaultLogMaxSize)
+	}
+	return logRetention{
+		maxSize:    size,
+		maxBackups: envInt("LOG_MAX_BACKUPS", defaultLogMaxBackups),
+		maxAge:     envDuration("LOG_MAX_AGE", defaultLogMaxAge),
+	}
+}
+
+func logDir() string {
+	return envString("LOG_DIR", defaultLogDir)
+}
+
+// volumeLogDir holds the logs of the juicefs clients, apart from the driver
+// and access logs, which a volume could otherwise be named like.
+func volumeLogDir() string {
+	return filepath.Join(logDir(), "volumes")
+}
+
+// volumeLogPath is the log file of a volume's juicefs client.
+func volumeLogPath(v *jfsVolume) string {
+	return filepath.Join(volumeLogDir(), dockerName(v)+".log")
+}
+
+// mountLogArg returns the --log argument directing a background client's
+// log into LOG_DIR, unless the volume sets its own `log` option.
+func mountLogArg(v *jfsVolume, opts map[string]string) []string {
+	if _, ok := opts["log"]; ok {
+		return nil
+	}
+	path := volumeLogPath(v)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.Warnf("cannot create log directory for volume %s: %s", v.Name, err)
+		return nil
+	}
+	return []string{"--log=" + path}
+}
+
+// rotatingFile is an append-only log file that rotates itself when it grows
+// beyond the retention size.
+type rotatingFile struct {
+	sync.Mutex
+	path      string
+	retention logRetention
+	f         *os.File
+	size      uint64
+}
+
+func openRotatingFile(path string, retention logRetention) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, retention: retention}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, uint64(fi.Size())
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.retention.maxSize > 0 && r.size+uint64(len(p)) > r.retention.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation of %s failed: %s\n", r.path, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += uint64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.f.Close()
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	if err := os.Rename(r.path, backupName(r.path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	pruneBackups(r.path, r.retention)
+	return nil
+}
+
+// backupTimeFormat is the suffix of rotated log copies.
+const backupTimeFormat = "20060102T150405.000000000"
+
+func backupName(path string) string {
+	return path + "." + time.Now().UTC().Format(backupTimeFormat)
+}
+
+// pruneBackups removes rotated copies of path beyond the retention count
+// or age. Only names with a rotation time count, so that the log of a volume
+// called e.g. x.log is not taken for a copy of the log of volume x.
+func pruneBackups(path string, retention logRetention) {
+	var matches []string
+	all, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return
+	}
+	for _, m := range all {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, path+".")); err == nil {
+			matches = append(matches, m)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	for i, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		expired := retention.maxAge > 0 && time.Since(fi.ModTime()) > retention.maxAge
+		if (retention.maxBackups > 0 && i >= retention.maxBackups) || expired {
+			if err := os.Remove(m); err != nil {
+				logrus.Warnf("failed to remove old log %s: %s", m, err)
+			}
+		}
+	}
+}
+
+// setupDriverLog tees the plugin's own log output into LOG_DIR/driver.log.
+func setupDriverLog() {
+	f, err := openRotatingFile(filepath.Join(logDir(), "driver.log"), logRetentionFromEnv())
+	if err != nil {
+		logrus.Warnf("logging to stderr only: %s", err)
+		return
+	}
+	setLogOutput(io.MultiWriter(os.Stderr, f))
+}
+
+// rotateMountLogs is the periodic job rotating the juicefs client logs in
+// LOG_DIR/volumes. The driver and access logs rotate themselves. The clients keep their log files open, so oversized logs are
+// copied and truncated in place rather than renamed.
+func rotateMountLogs() error {
+	retention := logRetentionFromEnv()
+	paths, err := filepath.Glob(filepath.Join(volumeLogDir(), "*.log"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if retention.maxSize > 0 && uint64(fi.Size()) > retention.maxSize {
+			if err := copyTruncate(path); err != nil {
+				logrus.Warnf("failed to rotate %s: %s", path, err)
+				continue
+			}
+		}
+		pruneBackups(path, retention)
+	}
+	return nil
+}
+
+func copyTruncate(path string) error {
+	src, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(backupName(path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return src.Truncate(0)
+}
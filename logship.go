@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logShipping configures pushing the plugin's logs to a log aggregator,
+// for clusters that cannot scrape the plugin container.
+type logShipping struct {
+	// Type is "loki" (push API) or "fluentd" (forward protocol).
+	Type string `json:"type"`
+	// URL is the base URL of Loki, e.g. http://loki:3100.
+	URL string `json:"url"`
+	// Address is the host:port of the Fluentd forward input.
+	Address string `json:"address"`
+	// Tag is the Fluentd tag, by default docker-volume-juicefs.
+	Tag string `json:"tag"`
+	// Labels are added to every Loki stream or Fluentd record.
+	Labels map[string]string `json:"labels"`
+	// BatchInterval is how often entries are pushed, by default 1s.
+	BatchInterval string `json:"batch_interval"`
+}
+
+const (
+	// logShipQueue is the number of entries buffered for shipping; entries
+	// logged while it is full are dropped.
+	logShipQueue = 10000
+	// logShipBatch is the maximum number of entries pushed at once.
+	logShipBatch = 1000
+)
+
+// shippedEntry is a log entry queued for shipping.
+type shippedEntry struct {
+	time    time.Time
+	level   string
+	message string
+	fields  map[string]string
+}
+
+// logShipper is a logrus hook queueing entries for a background pusher.
+type logShipper struct {
+	config   *logShipping
+	host     string
+	interval time.Duration
+	entries  chan shippedEntry
+	push     func([]shippedEntry) error
+
+	fluentd net.Conn
+}
+
+// setupLogShipping starts shipping the logs of every subsystem as
+// configured in the configuration file.
+func setupLogShipping(config *logShipping) error {
+	if config == nil {
+		return nil
+	}
+	s := &logShipper{config: config, interval: time.Second, entries: make(chan shippedEntry, logShipQueue)}
+	s.host, _ = os.Hostname()
+	if config.BatchInterval != "" {
+		d, err := time.ParseDuration(config.BatchInterval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid log_shipping batch_interval %q", config.BatchInterval)
+		}
+		s.interval = d
+	}
+	switch config.Type {
+	case "loki":
+		if config.URL == "" {
+			return fmt.Errorf("log_shipping to loki requires url")
+		}
+		s.push = s.pushLoki
+	case "fluentd":
+		if config.Address == "" {
+			return fmt.Errorf("log_shipping to fluentd requires address")
+		}
+		if config.Tag == "" {
+			config.Tag = "docker-volume-juicefs"
+		}
+		s.push = s.pushFluentd
+	default:
+		return fmt.Errorf("invalid log_shipping type %q: must be loki or fluentd", config.Type)
+	}
+	for _, l := range allLoggers() {
+		l.AddHook(s)
+	}
+	go s.run()
+	return nil
+}
+
+func (s *logShipper) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (s *logShipper) Fire(e *logrus.Entry) error {
+	fields := make(map[string]string, len(e.Data))
+	for k, val := range e.Data {
+		fields[k] = fmt.Sprint(val)
+	}
+	select {
+	case s.entries <- shippedEntry{time: e.Time, level: e.Level.String(), message: e.Message, fields: fields}:
+	default:
+	}
+	return nil
+}
+
+// run pushes the queued entries in batches. Errors are written to stderr
+// rather than logged, which would queue more entries. A failed batch is
+// dropped.
+func (s *logShipper) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var batch []shippedEntry
+	for {
+		select {
+		case e := <-s.entries:
+			batch = append(batch, e)
+			if len(batch) < logShipBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := s.push(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "shipping %d log entries to %s failed: %s\n", len(batch), s.config.Type, err)
+		}
+		batch = nil
+	}
+}
+
+// labels returns the labels of an entry: the configured ones, the host,
+// the level and, if set, the volume and request ID.
+func (s *logShipper) labels(e shippedEntry) map[string]string {
+	labels := map[string]string{"host": s.host, "level": e.level}
+	for k, val := range s.config.Labels {
+		labels[k] = val
+	}
+	for _, k := range []string{"volume", "request_id"} {
+		if val, ok := e.fields[k]; ok {
+			labels[k] = val
+		}
+	}
+	return labels
+}
+
+// line formats the message of an entry with its remaining fields.
+func (e shippedEntry) line() string {
+	var b strings.Builder
+	b.WriteString(e.message)
+	for _, k := range sortedKeys(e.fields) {
+		if k != "volume" && k != "request_id" {
+			fmt.Fprintf(&b, " %s=%q", k, e.fields[k])
+		}
+	}
+	return b.String()
+}
+
+var logShipClient = &http.Client{Timeout: 10 * time.Second}
+
+// pushLoki sends a batch to the Loki push API, one stream per label set.
+func (s *logShipper) pushLoki(batch []shippedEntry) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := map[string]*stream{}
+	var keys []string
+	for _, e := range batch {
+		labels := s.labels(e)
+		data, _ := json.Marshal(labels)
+		st, ok := streams[string(data)]
+		if !ok {
+			st = &stream{Stream: labels}
+			streams[string(data)] = st
+			keys = append(keys, string(data))
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line()})
+	}
+	sort.Strings(keys)
+	req := struct {
+		Streams []*stream `json:"streams"`
+	}{}
+	for _, k := range keys {
+		req.Streams = append(req.Streams, streams[k])
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := logShipClient.Post(strings.TrimSuffix(s.config.URL, "/")+"/loki/api/v1/push", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("loki returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pushFluentd sends a batch as one Forward mode message, [tag, [[time,
+// record], ...]], over a connection kept open between batches.
+func (s *logShipper) pushFluentd(batch []shippedEntry) error {
+	var buf bytes.Buffer
+	msgpackArray(&buf, 2)
+	msgpackString(&buf, s.config.Tag)
+	msgpackArray(&buf, len(batch))
+	for _, e := range batch {
+		record := s.labels(e)
+		record["message"] = e.line()
+		msgpackArray(&buf, 2)
+		msgpackInt(&buf, e.time.Unix())
+		msgpackMap(&buf, len(record))
+		for _, k := range sortedKeys(record) {
+			msgpackString(&buf, k)
+			msgpackString(&buf, record[k])
+		}
+	}
+
+	if s.fluentd == nil {
+		conn, err := net.DialTimeout("tcp", s.config.Address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.fluentd = conn
+	}
+	s.fluentd.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.fluentd.Write(buf.Bytes()); err != nil {
+		s.fluentd.Close()
+		s.fluentd = nil
+		return err
+	}
+	return nil
+}
+
+// The subset of MessagePack the forward protocol needs.
+
+func msgpackArray(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x90 | byte(n))
+	case n < 1<<16:
+		buf.WriteByte(0xdc)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdd)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func msgpackMap(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.WriteByte(0x80 | byte(n))
+	case n < 1<<16:
+		buf.WriteByte(0xde)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdf)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func msgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n < 1<<8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n < 1<<16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+func msgpackInt(buf *bytes.Buffer, i int64) {
+	buf.WriteByte(0xd3)
+	binary.Write(buf, binary.BigEndian, i)
+}
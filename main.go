@@ -2,20 +2,22 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,21 +41,6 @@ func isAuthUnsupported(output string) bool {
 		strings.Contains(out, "flag provided but not defined: --token")
 }
 
-func canonicalize(k string) string {
-	switch k {
-	case "accesskey":
-		return "access-key"
-	case "accesskey2":
-		return "access-key2"
-	case "secretkey":
-		return "secret-key"
-	case "secretkey2":
-		return "secret-key2"
-	default:
-		return k
-	}
-}
-
 // sanitizeOutput replaces any sensitive values with "****" so we can safely
 // log JuiceFS CLI output.
 func sanitizeOutput(out string, secrets []string) string {
@@ -68,9 +55,16 @@ func sanitizeOutput(out string, secrets []string) string {
 }
 
 // waitForMountReady polls the mountpoint until it becomes a JuiceFS mount
-// (root inode == 1) or times out.
-func waitForMountReady(mountpoint string) error {
-	touch := exec.Command("touch", filepath.Join(mountpoint, ".juicefs"))
+// (root inode == 1) that can be used in the volume's access mode, or times
+// out. Writability is only verified for read-write volumes, using a
+// temporary marker file that is removed again so user data stays untouched.
+func waitForMountReady(v *jfsVolume) (err error) {
+	defer observeOp("ready", v, time.Now(), &err)
+
+	mountpoint := v.Mountpoint
+	marker := filepath.Join(mountpoint, fmt.Sprintf(".juicefs-ready-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	readOnly := isReadOnly(v)
+	verify := readyVerify(v)
 	lastErr := fmt.Errorf("mountpoint %s did not become ready", mountpoint)
 
 	for attempt := 0; attempt < 10; attempt++ {
@@ -81,7 +75,13 @@ func waitForMountReady(mountpoint string) error {
 				return logError("Not a syscall.Stat_t")
 			}
 			if stat.Ino == 1 {
-				if err := touch.Run(); err == nil {
+				if err := checkMountAccess(v); err != nil {
+					return logError("%s", err)
+				}
+				if readOnly {
+					return nil
+				}
+				if err := probeWritable(marker, verify); err == nil {
 					return nil
 				}
 				lastErr = err
@@ -92,11 +92,41 @@ func waitForMountReady(mountpoint string) error {
 			lastErr = err
 		}
 
-		logrus.Debugf("Error in attempt %d waiting for %s: %#v", attempt+1, mountpoint, lastErr)
+		volumeLog(mountLog, v).Debugf("Error in attempt %d waiting for %s: %#v", attempt+1, mountpoint, lastErr)
 		time.Sleep(time.Second)
 	}
 
-	return logError(lastErr.Error())
+	return codedError(codeMountTimeout, "%s", lastErr)
+}
+
+// checkMountAccess verifies that the mounted root, which is the requested
+// subdir for `subdir` mounts, exists and can be listed.
+func checkMountAccess(v *jfsVolume) error {
+	f, err := os.Open(v.Mountpoint)
+	if err != nil {
+		if subdir := v.Options["subdir"]; subdir != "" {
+			return fmt.Errorf("subdir %s of volume %s is not accessible: %s", subdir, v.Name, err)
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		if subdir := v.Options["subdir"]; subdir != "" {
+			return fmt.Errorf("subdir %s of volume %s is not accessible: %s", subdir, v.Name, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// isReadOnly reports whether the volume is mounted read-only.
+func isReadOnly(v *jfsVolume) bool {
+	for _, k := range []string{"read-only", "ro"} {
+		if val, ok := v.Options[k]; ok && isFlagEnabled(val) {
+			return true
+		}
+	}
+	return false
 }
 
 // isJuiceFSMountedRoot checks if the given path is a JuiceFS mount root by
@@ -115,202 +145,368 @@ func isJuiceFSMountedRoot(path string) bool {
 }
 
 type jfsVolume struct {
-	Name        string
-	Options     map[string]string
-	Source      string
-	Mountpoint  string
-	connections int
+	Name       string
+	Options    map[string]string
+	Source     string
+	Mountpoint string
+	// Quarantine is set while mounts fail fast after repeated failures, as
+	// saved with the state, see quarantine.
+	Quarantine *quarantine `json:",omitempty"`
+	// Client is the juicefs process serving the volume while mounted, as
+	// saved with the state, see client.
+	Client *clientProcess `json:",omitempty"`
+	// LastError is the last failed format, mount or unmount, as saved
+	// with the state, see lastError.
+	LastError *volumeError `json:",omitempty"`
+	// Formatted is set once the plugin formatted the filesystem of a CE
+	// volume, as saved with the state, see formatted.
+	Formatted bool `json:",omitempty"`
+	// Events are the last events of the volume, see eventHistory.
+	Events []event `json:",omitempty"`
+	// MountIDs are the Docker mount requests the volume is mounted for,
+	// with the time of the request. It is saved with the state, so it is
+	// only changed while holding both mu and d's lock.
+	MountIDs map[string]time.Time `json:",omitempty"`
+	// connections counts the MountIDs, plus one while pinned by premount.
+	// It is changed under mu and read through connectionCount, also by
+	// the monitors and metrics holding only d's lock.
+	connections atomic.Int32
+	// alerts tracks which usage thresholds are currently exceeded.
+	alerts map[string]bool
+	// format is the background format started at Create, if any. It is
+	// read by Get under d's lock and cleared by Mount under mu.
+	format atomic.Pointer[formatJob]
+	// mu serializes mounting and unmounting the volume.
+	mu sync.Mutex
+	// pinned is set while premount holds a connection.
+	pinned bool
+	// unmountTimer is the pending unmount after the grace period.
+	unmountTimer *time.Timer
+	// lastUsed is when a container last mounted or unmounted the volume.
+	lastUsed time.Time
+	// credentialsRefreshed is when short-lived credentials were last pushed
+	// to JuiceFS.
+	credentialsRefreshed time.Time
+	// failures are the times of recent failed mounts.
+	failures []time.Time
+	// accessLog records the access log while mounted with access-log=true.
+	accessLog *accessLogger
+	// client is the current client process, changed under mu and copied
+	// to Client like lastError.
+	client atomic.Pointer[clientProcess]
+	// quarantine is the current quarantine, changed under mu and copied to
+	// Quarantine like lastError.
+	quarantine atomic.Pointer[quarantine]
+	// lastError is the last error as recorded by the operation that
+	// failed. It is copied to LastError under d's lock when the state is
+	// saved, so it can be recorded without it.
+	lastError atomic.Pointer[volumeError]
+	// formatted is set by a successful format and copied to Formatted
+	// like lastError.
+	formatted atomic.Bool
+	// request is the ID of the API call that last operated on the volume.
+	request atomic.Pointer[string]
 }
 
 type jfsDriver struct {
 	sync.RWMutex
 
-	root      string
-	statePath string
-	volumes   map[string]*jfsVolume
+	root    string
+	state   stateBackend
+	config  *driverConfig
+	volumes map[string]*jfsVolume
 }
 
-func newJfsDriver(root string) (*jfsDriver, error) {
+func newJfsDriver(root string, config *driverConfig) (*jfsDriver, error) {
 	logrus.WithField("method", "newJfsDriver").Debug(root)
 
 	d := &jfsDriver{
-		root:      filepath.Join(root, "volumes"),
-		statePath: filepath.Join(root, "state", "jfs-state.json"),
-		volumes:   map[string]*jfsVolume{},
+		root:    filepath.Join(root, "volumes"),
+		config:  config,
+		volumes: map[string]*jfsVolume{},
 	}
 
-	if data, err := ioutil.ReadFile(d.statePath); err != nil {
-		if os.IsNotExist(err) {
-			logrus.WithField("statePath", d.statePath).Debug("no state found")
-		} else {
-			return nil, err
-		}
-	} else {
-		if err := json.Unmarshal(data, &d.volumes); err != nil {
-			return nil, err
+	if err := prepareStateDir(filepath.Join(root, "state")); err != nil {
+		return nil, err
+	}
+	var err error
+	if d.state, err = newStateBackend(root); err != nil {
+		return nil, err
+	}
+	if err := d.loadState(); err != nil {
+		return nil, err
+	}
+	if path := os.Getenv("MIGRATE_FROM"); path != "" {
+		if err := d.migrateOfficialState(path); err != nil {
+			return nil, fmt.Errorf("migration from %s failed: %s", path, err)
 		}
 	}
+	d.forgetDeadClients()
 
 	return d, nil
 }
 
 func (d *jfsDriver) saveState() {
-	data, err := json.Marshal(d.volumes)
-	if err != nil {
-		logrus.WithField("statePath", d.statePath).Error(err)
+	if err := d.writeState(); err != nil {
+		logrus.WithField("saveState", d.state.String()).Error(err)
 	}
+}
 
-	if err := ioutil.WriteFile(d.statePath, data, 0600); err != nil {
-		logrus.WithField("saveState", d.statePath).Error(err)
-	}
+// ceFormatOptions are the options passed to `juicefs format` rather than
+// `juicefs mount`.
+var ceFormatOptions = []string{
+	"block-size",
+	"compress",
+	"shards",
+	"storage",
+	"bucket",
+	"access-key",
+	"secret-key",
+	"session-token",
+	"encrypt-rsa-key",
+	"trash-days",
+	"hash-prefix",
+	"capacity",
+	"inodes",
+	"storage-class",
+}
+
+// ceSharedOptions are the format options that `juicefs mount` takes as well.
+// The storage class given to format is only the default of the filesystem;
+// the one given to mount applies to the objects the client writes.
+var ceSharedOptions = map[string]bool{
+	"storage-class": true,
 }
 
-func ceMount(v *jfsVolume) error {
+// ceFormatCommand builds `juicefs format --no-update`, which creates the
+// filesystem or leaves an existing one alone, and returns it along with the
+// options left for `juicefs mount`.
+func ceFormatCommand(v *jfsVolume, env []string, opts map[string]string) (*exec.Cmd, map[string]string) {
 	options := map[string]string{}
 	format := exec.Command(ceCliPath, "format", "--no-update")
-	for k, val := range v.Options {
-		if k == "env" {
-			format.Env = append(os.Environ(), strings.Split(val, ",")...)
-			logrus.Debugf("modified env for volume %s: %v", v.Name, format.Env)
+	format.Env = env
+	for k, val := range opts {
+		k = canonicalize(k)
+		if isDriverOption(k) {
 			continue
 		}
 		options[k] = val
 	}
-	formatOptions := []string{
-		"block-size",
-		"compress",
-		"shards",
-		"storage",
-		"bucket",
-		"access-key",
-		"secret-key",
-		"encrypt-rsa-key",
-		"trash-days",
+	if isAzureStorage(options["storage"]) {
+		options["storage"] = "wasb"
 	}
-	for _, formatOption := range formatOptions {
+	for _, formatOption := range ceFormatOptions {
 		val, ok := options[formatOption]
 		if !ok {
 			continue
 		}
+		if !ceSharedOptions[formatOption] {
+			delete(options, formatOption)
+		}
+		if ceFormatSpecs[formatOption].kind == kindBool {
+			if isFlagEnabled(val) {
+				format.Args = append(format.Args, "--"+formatOption)
+			}
+			continue
+		}
 		format.Args = append(format.Args, fmt.Sprintf("--%s=%s", formatOption, val))
-		delete(options, formatOption)
 	}
 	format.Args = append(format.Args, v.Source, v.Name)
-	logrus.Debug(format)
-	if out, err := format.CombinedOutput(); err != nil {
-		logrus.Errorf("juicefs format error: %s", out)
-		return logError(err.Error())
+	return format, options
+}
+
+func runFormat(v *jfsVolume, format *exec.Cmd, secrets []string) (err error) {
+	defer observeOp("format", v, time.Now(), &err)
+
+	volumeLog(cliLog, v).Debug(sanitizeOutput(format.String(), secrets))
+	out, err := format.CombinedOutput()
+	recordOutput(v, format, out, err, secrets)
+	if err != nil {
+		msg := sanitizeOutput(string(bytes.TrimSpace(out)), secrets)
+		if msg == "" {
+			msg = err.Error()
+		}
+		return codedError(codeFormatFailed, "juicefs format failed for volume %s: %s", v.Name, msg)
+	}
+	v.formatted.Store(true)
+	return nil
+}
+
+func ceMount(v *jfsVolume, opts map[string]string) error {
+	env, err := optionEnv(opts)
+	if err != nil {
+		return logError("%s", err)
+	}
+	if err := checkFormatVersions(opts); err != nil {
+		return logError("%s", err)
+	}
+	format, options := ceFormatCommand(v, env, opts)
+	options = withMountDefaults(options)
+	if job := v.format.Load(); job != nil {
+		// The filesystem is being formatted in the background.
+		err = job.wait()
+		if err != nil {
+			// Format again on the next attempt.
+			v.format.Store(nil)
+		}
+	} else {
+		err = runFormat(v, format, volumeSecrets(v, opts))
+	}
+	if err != nil {
+		return err
 	}
 
 	// options left for `juicefs mount`
 	mount := exec.Command(ceCliPath, "mount")
 	// ensure we don't attempt to auto-download helper and prefer bundled one
-	mount.Env = append(os.Environ(), "JFS_NO_UPDATE=1")
+	mount.Env = append(env, "JFS_NO_UPDATE=1")
 	if _, err := os.Stat("/bin/jfsmount"); err == nil {
 		mount.Env = append(mount.Env, "JFS_MOUNT_BIN=/bin/jfsmount")
 	}
 	// run mount in background to avoid blocking and ensure child lifecycle isn't tied to plugin process
 	mount.Args = append(mount.Args, "-d")
-	mountFlags := []string{
-		"cache-partial-only",
-		"enable-xattr",
-		"no-syslog",
-		"no-usage-report",
-		"writeback",
-	}
-	for _, mountFlag := range mountFlags {
-		_, ok := options[mountFlag]
+	mount.Args = append(mount.Args, mountLogArg(v, options)...)
+	version, verErr := ceVersion()
+	if verErr != nil {
+		volumeLog(mountLog, v).Warnf("cannot detect juicefs version, skipping option version checks: %s", verErr)
+	}
+	for mountOption, val := range options {
+		spec, ok := ceMountOptions[mountOption]
 		if !ok {
+			mount.Args = append(mount.Args, fmt.Sprintf("--%s=%s", mountOption, val))
 			continue
 		}
-		mount.Args = append(mount.Args, fmt.Sprintf("--%s", mountFlag))
-		delete(options, mountFlag)
-	}
-	for mountOption, val := range options {
-		mount.Args = append(mount.Args, fmt.Sprintf("--%s=%s", mountOption, val))
+		if verErr == nil {
+			if err := checkOptionVersion(mountOption, spec, version); err != nil {
+				return logError("%s", err)
+			}
+		}
+		if spec.kind != kindBool {
+			mount.Args = append(mount.Args, fmt.Sprintf("--%s=%s", mountOption, val))
+		} else if isFlagEnabled(val) {
+			mount.Args = append(mount.Args, fmt.Sprintf("--%s", mountOption))
+		}
 	}
 	mount.Args = append(mount.Args, v.Source, v.Mountpoint)
-	logrus.Debug(mount)
+	volumeLog(cliLog, v).Debug(mount)
 	// Start mount in background to avoid waitid/ECHILD issues when the helper daemonizes.
 	if err := mount.Start(); err != nil {
-		return logError(err.Error())
+		return logError("%s", err)
 	}
 
-	return waitForMountReady(v.Mountpoint)
+	return waitForMountReady(v)
 }
 
-func eeMount(v *jfsVolume) error {
-	// Copy options so we can safely mutate them.
-	mountOpts := map[string]string{}
-	for k, val := range v.Options {
-		mountOpts[k] = val
+// eeEnv builds the environment for EE CLI invocations and returns it along
+// with the secret values that must be redacted from logged output.
+func eeEnv(v *jfsVolume, opts map[string]string) ([]string, []string, error) {
+	env, err := optionEnv(opts)
+	if err != nil {
+		return nil, nil, logError("%s", err)
 	}
 
-	// Build environment. "env" option is used only to inject env vars, not as a CLI flag.
-	env := os.Environ()
-	if envOpt, ok := mountOpts["env"]; ok && envOpt != "" {
-		env = append(env, strings.Split(envOpt, ",")...)
-		delete(mountOpts, "env")
-		logrus.Debugf("modified env for volume %s: %v", v.Name, env)
+	// Self-hosted consoles: the EE CLI reads the console address from
+	// BASE_URL, for auth as well as for the mount's config refreshes.
+	for _, k := range []string{"console-url", "base-url"} {
+		if val, ok := opts[k]; ok {
+			if val != "" {
+				env = append(env, "BASE_URL="+val)
+			}
+			delete(opts, k)
+		}
 	}
 
 	// Secrets for log redaction.
 	secrets := []string{
-		mountOpts["token"],
-		mountOpts["access-key"],
-		mountOpts["accesskey"],
-		mountOpts["access-key2"],
-		mountOpts["accesskey2"],
-		mountOpts["secret-key"],
-		mountOpts["secretkey"],
-		mountOpts["secret-key2"],
-		mountOpts["secretkey2"],
+		opts["token"],
+		opts["access-key"],
+		opts["accesskey"],
+		opts["access-key2"],
+		opts["accesskey2"],
+		opts["secret-key"],
+		opts["secretkey"],
+		opts["secret-key2"],
+		opts["secretkey2"],
+		opts["session-token"],
+		opts["bucket"],
+		opts["bucket2"],
 	}
 
-	// Map storage credentials to environment variables instead of CLI flags.
-	// This keeps them out of logs and avoids CLI option changes breaking mounts.
-	if val, ok := mountOpts["access-key"]; ok && val != "" {
-		env = append(env, "ACCESS_KEY="+val)
-	}
-	if val, ok := mountOpts["accesskey"]; ok && val != "" {
-		env = append(env, "ACCESS_KEY="+val)
+	return env, secrets, nil
+}
+
+// eeAuthOptions are passed to `juicefs auth` rather than `juicefs mount`.
+// On-premises deployments commonly need to override the bucket endpoints
+// configured in the console.
+var eeAuthOptions = []string{"bucket", "bucket2", "storage"}
+
+// eeAuth runs `juicefs auth NAME --token=... [--bucket=...]` so the EE client
+// has an up-to-date configuration for the volume.
+func eeAuth(v *jfsVolume, env []string, opts map[string]string, secrets []string) error {
+	auth := exec.Command(eeCliPath, "auth", v.Name)
+	auth.Env = env
+	if token := opts["token"]; token != "" {
+		auth.Args = append(auth.Args, fmt.Sprintf("--token=%s", token))
 	}
-	if val, ok := mountOpts["access-key2"]; ok && val != "" {
-		env = append(env, "ACCESS_KEY2="+val)
+	for _, k := range eeAuthOptions {
+		if val := opts[k]; val != "" {
+			auth.Args = append(auth.Args, fmt.Sprintf("--%s=%s", k, val))
+		}
 	}
-	if val, ok := mountOpts["accesskey2"]; ok && val != "" {
-		env = append(env, "ACCESS_KEY2="+val)
+	volumeLog(cliLog, v).Debug(sanitizeOutput(auth.String(), secrets))
+	start := time.Now()
+	out, err := auth.CombinedOutput()
+	observeOp("auth", v, start, &err)
+	recordOutput(v, auth, out, err, secrets)
+	if err != nil {
+		msg := sanitizeOutput(string(bytes.TrimSpace(out)), secrets)
+		return codedError(codeAuthFailed, "juicefs auth failed for volume %s: %s", v.Name, msg)
 	}
-	if val, ok := mountOpts["secret-key"]; ok && val != "" {
-		env = append(env, "SECRET_KEY="+val)
+	return nil
+}
+
+// eeMountFlags are the boolean options of the EE `juicefs mount`.
+var eeMountFlags = []string{
+	"external",
+	"internal",
+	"gc",
+	"dry",
+	"flip",
+	"no-sync",
+	"allow-other",
+	"allow-root",
+	"enable-xattr",
+}
+
+func isEEMountFlag(k string) bool {
+	for _, f := range eeMountFlags {
+		if f == k {
+			return true
+		}
 	}
-	if val, ok := mountOpts["secretkey"]; ok && val != "" {
-		env = append(env, "SECRET_KEY="+val)
+	return false
+}
+
+func eeMount(v *jfsVolume, opts map[string]string) error {
+	// Copy options so we can safely mutate them.
+	mountOpts := map[string]string{}
+	for k, val := range opts {
+		if isDriverOption(k) {
+			continue
+		}
+		mountOpts[k] = val
 	}
-	if val, ok := mountOpts["secret-key2"]; ok && val != "" {
-		env = append(env, "SECRET_KEY2="+val)
+
+	// The console address goes to the environment, not to `juicefs mount`.
+	env, secrets, err := eeEnv(v, mountOpts)
+	if err != nil {
+		return err
 	}
-	if val, ok := mountOpts["secretkey2"]; ok && val != "" {
-		env = append(env, "SECRET_KEY2="+val)
+	if err := eeAuth(v, env, mountOpts, secrets); err != nil {
+		return err
 	}
 
-		// ---- EE auth: juicefs auth NAME --token=... ----
-		authToken := ""
-		if val, ok := mountOpts["token"]; ok && val != "" {
-			authToken = val
-		}
-		auth := exec.Command(eeCliPath, "auth", v.Name)
-		auth.Env = env
-		if authToken != "" {
-			auth.Args = append(auth.Args, fmt.Sprintf("--token=%s", authToken))
-		}
-		logrus.Debug(auth)
-		if out, err := auth.CombinedOutput(); err != nil {
-			msg := sanitizeOutput(string(bytes.TrimSpace(out)), secrets)
-			return logError("juicefs auth failed for volume %s: %s", v.Name, msg)
-		}
-	
-		// ---- EE mount: juicefs mount NAME MOUNTPOINT [options] ----
+	// ---- EE mount: juicefs mount NAME MOUNTPOINT [options] ----
 
 	mount := exec.Command(eeCliPath, "mount", v.Name, v.Mountpoint)
 	// do not auto-download jfsmount; prefer bundled helper if present
@@ -320,18 +516,7 @@ func eeMount(v *jfsVolume) error {
 	}
 	// run mount in background for EE
 	mount.Args = append(mount.Args, "-d")
-
-	mountFlags := []string{
-		"external",
-		"internal",
-		"gc",
-		"dry",
-		"flip",
-		"no-sync",
-		"allow-other",
-		"allow-root",
-		"enable-xattr",
-	}
+	mount.Args = append(mount.Args, mountLogArg(v, opts)...)
 
 	// Normalize option names for mount.
 	norm := map[string]string{}
@@ -340,19 +525,20 @@ func eeMount(v *jfsVolume) error {
 	}
 	mountOpts = norm
 
-		// Capture token separately for potential future use; current CLI flow
-		// only requires it during auth, not mount.
+	// Capture token separately for potential future use; current CLI flow
+	// only requires it during auth, not mount.
 	token := ""
 	if val, ok := mountOpts["token"]; ok && val != "" {
 		token = val
 		delete(mountOpts, "token")
 	}
 
-	// Object storage credentials belong in env, not as `mount` flags.
-	// Strip all storage-related options before building the mount args.
+	// Object storage credentials belong in env, and bucket/storage overrides
+	// were already given to `juicefs auth`, so none of them are `mount` flags.
 	for _, k := range []string{
 		"access-key", "accesskey", "access-key2", "accesskey2",
 		"secret-key", "secretkey", "secret-key2", "secretkey2",
+		"session-token",
 		"bucket", "bucket2",
 		"storage",
 	} {
@@ -360,7 +546,7 @@ func eeMount(v *jfsVolume) error {
 	}
 
 	// Append flags and k=v options
-	for _, mountFlag := range mountFlags {
+	for _, mountFlag := range eeMountFlags {
 		if _, ok := mountOpts[mountFlag]; ok {
 			mount.Args = append(mount.Args, fmt.Sprintf("--%s", mountFlag))
 			delete(mountOpts, mountFlag)
@@ -372,7 +558,7 @@ func eeMount(v *jfsVolume) error {
 	if token != "" {
 		mount.Args = append(mount.Args, fmt.Sprintf("--token=%s", token))
 	}
-	logrus.Debug(mount)
+	volumeLog(cliLog, v).Debug(mount)
 
 	// Capture output in the background so we can log errors (sanitized) without blocking.
 	stdout, _ := mount.StdoutPipe()
@@ -385,191 +571,558 @@ func eeMount(v *jfsVolume) error {
 	go func() {
 		var buf bytes.Buffer
 		_, _ = io.Copy(&buf, io.MultiReader(stdout, stderr))
-		if err := mount.Wait(); err != nil {
+		err := mount.Wait()
+		recordOutput(v, mount, buf.Bytes(), err, secrets)
+		if err != nil {
 			msg := sanitizeOutput(buf.String(), secrets)
 			// When the helper daemonizes, Wait can return errors like ECHILD; treat as debug.
-			logrus.Debugf("juicefs mount process for volume %s exited with error (may be benign if daemonized): %s", v.Name, msg)
+			volumeLog(cliLog, v).Debugf("juicefs mount process for volume %s exited with error (may be benign if daemonized): %s", v.Name, msg)
 		}
 	}()
 
 	// Finally, poll for the mount to become ready.
-	return waitForMountReady(v.Mountpoint)
+	return waitForMountReady(v)
+}
+
+// mountVolume mounts the volume, retrying transient failures with
+// exponential backoff.
+func mountVolume(v *jfsVolume) (err error) {
+	defer observeOp("mount", v, time.Now(), &err)
+
+	retries := mountRetries(v)
+	for attempt := 0; ; attempt++ {
+		err = mountOnce(v)
+		if err == nil {
+			trackClient(v)
+			trackSession(v)
+			startAccessLog(v)
+			return nil
+		}
+		if attempt >= retries || !isTransientMountError(err) {
+			return err
+		}
+		backoff := retryBackoff(attempt + 1)
+		volumeLog(mountLog, v).Warnf("mount attempt %d of %d failed, retrying in %s: %s", attempt+1, retries+1, backoff.Round(time.Millisecond), err)
+		if isJuiceFSMountedRoot(v.Mountpoint) {
+			// Do not stack the next attempt on a half-working mount.
+			if err := umountVolume(v); err != nil {
+				volumeLog(mountLog, v).Warn(err)
+			}
+		}
+		time.Sleep(backoff)
+	}
 }
 
-func mountVolume(v *jfsVolume) error {
+func mountOnce(v *jfsVolume) error {
 	fi, err := os.Lstat(v.Mountpoint)
 	if os.IsNotExist(err) {
 		if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
-			return logError(err.Error())
+			return logError("%s", err)
 		}
 	} else if err != nil {
-		return logError(err.Error())
+		return logError("%s", err)
 	}
 
 	if fi != nil && !fi.IsDir() {
 		return logError("%v already exist and it's not a directory", v.Mountpoint)
 	}
 
-	if !strings.Contains(v.Source, "://") {
-		return eeMount(v)
+	opts, err := mountOptions(v)
+	if err != nil {
+		return logError("failed to expand options of volume %s: %s", v.Name, err)
 	}
-	return ceMount(v)
+	if !isCommunityEdition(v) {
+		return eeMount(v, opts)
+	}
+	return ceMount(v, opts)
+}
+
+// dockerName returns the Docker name of the volume, which is also the last
+// element of its mountpoint.
+func dockerName(v *jfsVolume) string {
+	return filepath.Base(v.Mountpoint)
+}
+
+// connectionCount returns how many containers use the volume, plus one
+// while it is pinned by premount.
+func (v *jfsVolume) connectionCount() int {
+	return int(v.connections.Load())
+}
+
+// isCommunityEdition reports whether the volume is served by the CE client,
+// i.e. it was created with a metaurl rather than an EE volume name.
+func isCommunityEdition(v *jfsVolume) bool {
+	return strings.Contains(v.Source, "://")
 }
 
-func umountVolume(v *jfsVolume) error {
-	cmd := exec.Command("umount", v.Mountpoint)
-	logrus.Debug(cmd)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		logrus.Errorf("juicefs umount error: %s", out)
-		return logError(err.Error())
+func umountVolume(v *jfsVolume) (err error) {
+	defer observeOp("unmount", v, time.Now(), &err)
+	stopAccessLog(v)
+
+	cmd, err := juicefsUmount(v, false)
+	if err == errUmountUnsupported {
+		cmd = exec.Command("umount", v.Mountpoint)
+		volumeLog(cliLog, v).Debug(cmd)
+		var out []byte
+		out, err = cmd.CombinedOutput()
+		recordOutput(v, cmd, out, err, nil)
+		if err != nil {
+			volumeLog(cliLog, v).Errorf("umount error: %s", out)
+		}
+	}
+	if err != nil {
+		return logError("%s", err)
 	}
+	v.client.Store(nil)
 	return nil
 }
 
-func (d *jfsDriver) Create(r *volume.CreateRequest) error {
-	logrus.WithField("method", "create").Debugf("%#v", r)
+func (d *jfsDriver) Create(ctx context.Context, r *volume.CreateRequest) error {
+	requestLog(apiLog, ctx).WithField("method", "create").Debugf("%#v", r)
 
 	d.Lock()
-	defer d.Unlock()
+	prev := d.volumes[r.Name]
+	v, err := d.newVolume(r.Name, r.Options)
+	d.Unlock()
+	if err != nil {
+		return err
+	}
+	v.setRequest(ctx)
+	// The connectivity checks run without the lock, so a slow endpoint does
+	// not hold up the API calls for other volumes.
+	if err := preflight(v); err != nil {
+		return err
+	}
+
+	d.RLock()
+	err = d.checkCreate(r.Name, prev, v)
+	mounted := d.mountedVolumes()
+	d.RUnlock()
+	if err != nil {
+		return err
+	}
+	if val, ok := v.Options["async-format"]; ok && isFlagEnabled(val) && isCommunityEdition(v) {
+		if err := startFormat(v); err != nil {
+			return err
+		}
+	}
+	// Like the preflight, the premount runs without the lock. The volume
+	// is not known to the other API calls until it is added below.
+	if isPremount(v) {
+		if err := premount(r.Name, v, mounted); err != nil {
+			return err
+		}
+	}
+
+	d.Lock()
+	err = d.checkCreate(r.Name, prev, v)
+	if err == nil {
+		d.volumes[r.Name] = v
+		warnDeprecated(r.Name, v)
+		d.saveState()
+	}
+	d.Unlock()
+	if err != nil {
+		if isPremount(v) {
+			v.mu.Lock()
+			releasePremount(r.Name, v)
+			v.mu.Unlock()
+		}
+		return err
+	}
+	emitEvent("created", r.Name, "", nil)
+	return nil
+}
 
+// checkCreate fails if the volume called name was created or removed by
+// another call since prev was looked up, or if v would exceed the volume
+// quota of its tenant. The caller holds d's lock.
+func (d *jfsDriver) checkCreate(name string, prev, v *jfsVolume) error {
+	if d.volumes[name] != prev {
+		return codedError(codeCreateFailed, "volume %s was changed while it was being created, try again", name)
+	}
+	if err := d.checkTenantQuota(v.Options["tenant"]); err != nil {
+		return withCode(codePolicyDenied, err)
+	}
+	return nil
+}
+
+// newVolume builds the volume called name from the options given at Create
+// and runs the admission checks that need no network: tenant, inheritance,
+// profile and name defaults are applied, the options validated and the
+// policy enforced. The `resolve` entries of the volume are added to the
+// hosts file so that the preflight checks can use them. The caller holds
+// d's lock.
+func (d *jfsDriver) newVolume(name string, given map[string]string) (*jfsVolume, error) {
 	v := &jfsVolume{
 		Options: map[string]string{},
 	}
 
-	for key, val := range r.Options {
+	if err := checkVolumeName(name); err != nil {
+		return nil, codedError(codeInvalidOption, "%s", err)
+	}
+	if err := checkOptionInput(given); err != nil {
+		return nil, codedError(codeInvalidOption, "%s", err)
+	}
+	options, err := d.applyTenant(name, given)
+	if err != nil {
+		return nil, withCode(codePolicyDenied, err)
+	}
+	options, err = d.applyInherit(options)
+	if err != nil {
+		return nil, withCode(codeInvalidOption, err)
+	}
+	options, err = d.applyProfile(options)
+	if err != nil {
+		return nil, withCode(codeInvalidOption, err)
+	}
+	options = d.applyNameDefaults(name, options)
+
+	for key, val := range options {
+		// Configured aliases are stored under the canonical name.
+		if canonical, ok := optionAliases[key]; ok {
+			key = canonical
+		}
+		if upstreamCompat {
+			var keep bool
+			if key, val, keep = compatOption(key, val); !keep {
+				continue
+			}
+		}
 		switch key {
 		case "name":
 			v.Name = val
 		case "metaurl":
-			v.Source = val
-			if !strings.Contains(v.Source, "://") {
-				// Default scheme of meta URL is redis://
-				v.Source = "redis://" + v.Source
-			}
+			// Default scheme of meta URL is redis://
+			v.Source = normalizeMetaURL(val)
 		default:
 			v.Options[key] = val
 		}
 	}
 
 	if v.Name == "" {
-		return logError("'name' option required")
+		return nil, codedError(codeInvalidOption, "'name' option required")
 	}
 	if v.Source == "" {
 		v.Source = v.Name
 	}
-
-	v.Mountpoint = filepath.Join(d.root, r.Name)
-	d.volumes[r.Name] = v
-
-	d.saveState()
-	return nil
+	v.Mountpoint = filepath.Join(d.root, name)
+	if err := checkUnknownOptions(name, v); err != nil {
+		return nil, codedError(codeInvalidOption, "%s", err)
+	}
+	if err := validateOptions(v); err != nil {
+		return nil, withCode(codeInvalidOption, err)
+	}
+	if err := checkSecretRefs(v); err != nil {
+		return nil, codedError(codeInvalidOption, "%s", err)
+	}
+	if err := d.config.Policy.admit(v); err != nil {
+		return nil, codedError(codePolicyDenied, "volume %s rejected by policy: %s", name, err)
+	}
+	// Names given with resolve= must resolve for the preflight checks too.
+	if err := d.writeHosts(v); err != nil {
+		return nil, codedError(codeInvalidOption, "%s", err)
+	}
+	return v, nil
 }
 
-func (d *jfsDriver) Remove(r *volume.RemoveRequest) error {
-	logrus.WithField("method", "remove").Debugf("%#v", r)
+// applyInherit merges the options of the volume named by `inherit=` underneath
+// the options given at Create. The source's filesystem name and metaurl are
+// not inherited. A volume can only inherit from a volume of its own tenant,
+// or from one without a tenant if it has none either, so credentials do not
+// leak across tenants.
+func (d *jfsDriver) applyInherit(options map[string]string) (map[string]string, error) {
+	name, ok := options["inherit"]
+	if !ok {
+		return options, nil
+	}
+	src, ok := d.volumes[name]
+	if !ok {
+		return nil, logError("cannot inherit from volume %s: not found", name)
+	}
+	if src.Options["tenant"] != options["tenant"] {
+		return nil, logError("cannot inherit from volume %s of another tenant", name)
+	}
+	merged := map[string]string{}
+	for k, val := range src.Options {
+		merged[k] = val
+	}
+	for k, val := range options {
+		merged[k] = val
+	}
+	return merged, nil
+}
 
-	d.Lock()
-	defer d.Unlock()
+func (d *jfsDriver) Remove(ctx context.Context, r *volume.RemoveRequest) error {
+	requestLog(apiLog, ctx).WithField("method", "remove").Debugf("%#v", r)
 
+	d.RLock()
 	v, ok := d.volumes[r.Name]
+	d.RUnlock()
 
 	if !ok {
-		return logError("volume %s not found", r.Name)
+		return codedError(codeVolumeNotFound, "volume %s not found", r.Name)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.setRequest(ctx)
+
+	if v.connectionCount() != 0 && !(v.pinned && v.connectionCount() == 1) {
+		return codedError(codeVolumeInUse, "volume %s is in use", r.Name)
+	}
+	if err := releasePremount(r.Name, v); err != nil {
+		return err
 	}
+	if err := unmountScheduled(r.Name, v); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
 
-	if v.connections != 0 {
-		return logError("volume %s is in use", r.Name)
+	if d.volumes[r.Name] != v {
+		return codedError(codeVolumeNotFound, "volume %s not found", r.Name)
 	}
 
 	if err := os.Remove(v.Mountpoint); err != nil {
 		// Be tolerant when the mountpoint directory is already gone
 		// so that probe/test volumes can be cleaned up without errors.
 		if !os.IsNotExist(err) {
-			return logError(err.Error())
+			return logError("%s", err)
 		}
 	}
 
 	delete(d.volumes, r.Name)
 	d.saveState()
+	forgetUsageAlerts(r.Name)
+	clientSessions.Delete(r.Name)
+	if err := d.writeHosts(nil); err != nil {
+		logrus.Warnf("cannot update %s: %s", hostsFile, err)
+	}
+	emitEvent("removed", r.Name, "", nil)
 	return nil
 }
 
 func (d *jfsDriver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
-	logrus.WithField("method", "path").Debugf("%#v", r)
+	apiLog.WithField("method", "path").Debugf("%#v", r)
 
 	d.RLock()
 	defer d.RUnlock()
 
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return &volume.PathResponse{}, logError("volume %s not found", r.Name)
+		return &volume.PathResponse{}, codedError(codeVolumeNotFound, "volume %s not found", r.Name)
 	}
 
 	return &volume.PathResponse{Mountpoint: v.Mountpoint}, nil
 }
 
-func (d *jfsDriver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
-	logrus.WithField("method", "mount").Debugf("%#v", r)
+func (d *jfsDriver) Mount(ctx context.Context, r *volume.MountRequest) (*volume.MountResponse, error) {
+	requestLog(apiLog, ctx).WithField("method", "mount").Debugf("%#v", r)
 
+	d.RLock()
 	v, ok := d.volumes[r.Name]
+	mounted := d.mountedVolumes()
+	d.RUnlock()
 	if !ok {
-		return &volume.MountResponse{}, logError("volume %s not found", r.Name)
+		return &volume.MountResponse{}, codedError(codeVolumeNotFound, "volume %s not found", r.Name)
 	}
 
-	err := mountVolume(v)
-	if err != nil {
-		return &volume.MountResponse{}, logError("failed to mount %s: %s", r.Name, err)
+	// The lock order is v.mu before d's lock, so the state is saved once
+	// v.mu has been released.
+	changed, shared := false, false
+	defer func() {
+		if changed {
+			d.Lock()
+			d.saveState()
+			d.Unlock()
+		}
+	}()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.setRequest(ctx)
+
+	cancelScheduledUnmount(v)
+	v.lastUsed = time.Now()
+	warnDeprecated(r.Name, v)
+	// Containers share the mount. A mount left behind by a failed unmount
+	// is reused as well.
+	if v.connectionCount() == 0 && !isJuiceFSMountedRoot(v.Mountpoint) {
+		expired, err := checkQuarantine(r.Name, v)
+		changed = expired
+		if err != nil {
+			return &volume.MountResponse{}, codedError(codeQuarantined, "%s", err)
+		}
+		if err := checkMountLimit(r.Name, mounted); err != nil {
+			return &volume.MountResponse{}, codedError(codeMountLimit, "%s", err)
+		}
+		if err := checkVolumeConflicts(v); err != nil {
+			return &volume.MountResponse{}, codedError(codeInvalidOption, "volume %s: %s", r.Name, err)
+		}
+		err = mountVolume(v)
+		if err != nil {
+			changed = recordMountFailure(r.Name, v, err) || changed
+			emitEvent("mount_failed", r.Name, err.Error(), nil)
+			return &volume.MountResponse{}, logError("failed to mount %s: %s", r.Name, err)
+		}
+		v.failures = nil
+		changed = true
+		emitEvent("mounted", r.Name, "", map[string]interface{}{"mount_id": r.ID})
+	} else {
+		shared = true
 	}
 
-	v.connections++
+	d.Lock()
+	if v.MountIDs == nil {
+		v.MountIDs = map[string]time.Time{}
+	}
+	_, known := v.MountIDs[r.ID]
+	v.MountIDs[r.ID] = time.Now().UTC()
+	d.Unlock()
+	// A repeated request for the same mount does not count twice.
+	if !known {
+		v.connections.Add(1)
+		if shared {
+			emitEvent("mounted", r.Name, "sharing the existing mount", map[string]interface{}{"mount_id": r.ID})
+		}
+	}
+	changed = true
 	return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
 }
 
-func (d *jfsDriver) Unmount(r *volume.UnmountRequest) error {
-	logrus.WithField("method", "umount").Debugf("%#v", r)
+func (d *jfsDriver) Unmount(ctx context.Context, r *volume.UnmountRequest) error {
+	requestLog(apiLog, ctx).WithField("method", "umount").Debugf("%#v", r)
 
+	d.RLock()
 	v, ok := d.volumes[r.Name]
+	d.RUnlock()
 	if !ok {
-		return logError("volume %s not found", r.Name)
+		return codedError(codeVolumeNotFound, "volume %s not found", r.Name)
+	}
+
+	// Save the client process once v.mu has been released, see Mount.
+	defer func() {
+		d.Lock()
+		d.saveState()
+		d.Unlock()
+	}()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.setRequest(ctx)
+
+	d.Lock()
+	_, known := v.MountIDs[r.ID]
+	delete(v.MountIDs, r.ID)
+	d.Unlock()
+	if !known {
+		// E.g. a mount of the previous run whose volume was not mounted
+		// anymore when the plugin started.
+		volumeLog(apiLog, v).Warnf("ignoring unmount for unknown mount %s", r.ID)
+		return nil
+	}
+	v.connections.Store(int32(max(v.connectionCount()-1, 0)))
+	v.lastUsed = time.Now()
+	details := map[string]interface{}{"mount_id": r.ID}
+	if v.connectionCount() > 0 {
+		emitEvent("unmounted", r.Name, "released, the mount stays in use", details)
+		return nil
+	}
+	if grace := unmountGrace(v); grace > 0 {
+		emitEvent("unmounted", r.Name, fmt.Sprintf("released, unmounting in %s", grace), details)
+		scheduleUnmount(r.Name, v, grace)
+		return nil
+	}
+	emitEvent("unmounted", r.Name, "released, unmounting", details)
+	return teardownVolume(r.Name, v)
+}
+
+// teardownVolume unmounts a volume nothing uses anymore, waiting for
+// writeback uploads and open files first as configured. On error the
+// volume may still be mounted; the next Mount reuses it.
+func teardownVolume(name string, v *jfsVolume) error {
+	if isWriteback(v) {
+		if err := flushVolume(v); err != nil {
+			// Keep the client running so it can finish uploading.
+			return logError("not unmounting %s: %s", name, err)
+		}
+	}
+
+	// The access log reader would keep the mount busy.
+	stopAccessLog(v)
+	if timeout := drainTimeout(v); timeout > 0 {
+		if err := drainVolume(v, timeout); err != nil {
+			if !lazyUnmount(v) {
+				startAccessLog(v)
+				return logError("not unmounting %s: %s", name, err)
+			}
+			volumeLog(mountLog, v).Warnf("%s, detaching lazily", err)
+			if err := lazyUmountVolume(v); err != nil {
+				return logError("failed to lazily umount %s: %s", name, err)
+			}
+			emitEvent("unmounted", name, "", nil)
+			return nil
+		}
 	}
 
 	if err := umountVolume(v); err != nil {
-		return logError("failed to umount %s: %s", r.Name, err)
+		switch {
+		case isWedged(v):
+			volumeLog(mountLog, v).Warnf("failed to umount %s and its client is not responding, forcing: %s", name, err)
+			if err := forceUmountVolume(name, v); err != nil {
+				return logError("failed to umount %s: %s", name, err)
+			}
+		case lazyUnmount(v):
+			volumeLog(mountLog, v).Warnf("failed to umount %s, detaching lazily: %s", name, err)
+			if err := lazyUmountVolume(v); err != nil {
+				return logError("failed to lazily umount %s: %s", name, err)
+			}
+		default:
+			// Busy, e.g. used by a process outside of Docker.
+			startAccessLog(v)
+			return logError("failed to umount %s: %s", name, err)
+		}
 	}
 
-	v.connections--
+	emitEvent("unmounted", name, "", nil)
 	return nil
 }
 
 func (d *jfsDriver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
-	logrus.WithField("method", "get").Debugf("%#v", r)
+	apiLog.WithField("method", "get").Debugf("%#v", r)
 
 	d.Lock()
 	defer d.Unlock()
 
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return &volume.GetResponse{}, logError("volume %s not found", r.Name)
+		return &volume.GetResponse{}, codedError(codeVolumeNotFound, "volume %s not found", r.Name)
 	}
 
-	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint}}, nil
+	status := volumeStatus(v)
+	d.addMountLimit(status)
+	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint, Status: status}}, nil
 }
 
 func (d *jfsDriver) List() (*volume.ListResponse, error) {
-	logrus.WithField("method", "list").Debugf("")
+	apiLog.WithField("method", "list").Debugf("")
 
 	d.Lock()
 	defer d.Unlock()
 
 	var vols []*volume.Volume
-	for name, v := range d.volumes {
+	for _, name := range sortedKeys(d.volumes) {
+		v := d.volumes[name]
+		if !d.config.List.matches(name, v) {
+			continue
+		}
 		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.Mountpoint})
 	}
 	return &volume.ListResponse{Volumes: vols}, nil
 }
 
 func (d *jfsDriver) Capabilities() *volume.CapabilitiesResponse {
-	logrus.WithField("method", "capabilities").Debugf("")
+	apiLog.WithField("method", "capabilities").Debugf("")
 
 	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: "local"}}
 }
@@ -580,16 +1133,128 @@ func logError(format string, args ...interface{}) error {
 }
 
 func main() {
-    debug := os.Getenv("DEBUG")
-    if ok, _ := strconv.ParseBool(debug); ok {
-        logrus.SetLevel(logrus.DebugLevel)
-    }
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(getBuildInfo())
+		return
+	}
+
+	debug := os.Getenv("DEBUG")
+	if ok, _ := strconv.ParseBool(debug); ok {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	setupLogLevels()
+	setupDriverLog()
+	if err := setupSyslog(); err != nil {
+		logrus.Warnf("not forwarding logs to syslog: %s", err)
+	}
+	logrus.Info(getBuildInfo())
+
+	configPath := os.Getenv("CONFIG_FILE")
+	if configPath == "" {
+		configPath = defaultConfigPath
+	}
+	config, err := loadConfig(configPath)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := setupLogShipping(config.LogShipping); err != nil {
+		logrus.Warnf("not shipping logs: %s", err)
+	}
+	if err := setupAlerting(config.Alerting); err != nil {
+		logrus.Fatal(err)
+	}
+	addStorageEnv(config.StorageEnv)
+	if err := setCompatMode(os.Getenv("COMPAT_MODE")); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := setOptionsMode(os.Getenv("OPTIONS_MODE")); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := addOptionAliases(config.OptionAliases); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := addFormatOptions(config.FormatOptions); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := addMountFlags(config.MountFlags); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if path := os.Getenv("HA_LOCK"); path != "" {
+		if err := acquireLeadership(path); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	d, err := newJfsDriver("/jfs", config)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	d, err := newJfsDriver("/jfs")
+	prometheus.MustRegister(newDriverCollector(d))
+	if err := setupStatsd(d); err != nil {
+		logrus.Warnf("not emitting StatsD metrics: %s", err)
+	}
+	if envBool("CLIENT_METRICS", true) {
+		prometheus.MustRegister(&clientCollector{d: d})
+	}
+	schedule("usage", envDuration("MONITOR_INTERVAL", time.Minute), d.checkUsage)
+	schedule("watchdog", envDuration("WATCHDOG_INTERVAL", 30*time.Second), d.repairDeadMounts)
+	schedule("idle-unmount", envDuration("MONITOR_INTERVAL", time.Minute), d.unmountIdle)
+	schedule("credential-refresh", envDuration("MONITOR_INTERVAL", time.Minute), d.refreshSessionCredentials)
+	schedule("log-rotation", envDuration("LOG_ROTATE_INTERVAL", 10*time.Minute), rotateMountLogs)
+	schedule("meta-backups", envDuration("MONITOR_INTERVAL", time.Minute), d.checkMetaBackups)
+	schedule("orphans", envDuration("ORPHAN_CHECK_INTERVAL", time.Hour), d.checkOrphans)
+	schedule("stacked-mounts", envDuration("STACKED_MOUNT_INTERVAL", time.Minute), d.collapseStackedMounts)
+	schedule("zombie-reaper", envDuration("REAP_INTERVAL", 10*time.Second), newZombieReaper().reap)
+
+	adminSocket := os.Getenv("ADMIN_SOCKET")
+	if adminSocket == "" {
+		adminSocket = defaultAdminSocket
+	}
+	go func() {
+		logrus.Error(newAdminServer(d).serveUnix(adminSocket))
+	}()
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go func() {
+			logrus.Error(serveMetrics(addr, d))
+		}()
+	}
+
+	gid, err := socketGroup()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	mode, err := socketMode()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	l, err := listenUnix(socketAddress, gid, mode)
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	h := volume.NewHandler(d)
-	logrus.Infof("listening on %s", socketAddress)
-	logrus.Error(h.ServeUnix(socketAddress, 0))
+	h := volume.NewHandler(startupGate{d: d})
+	served := make(chan error, 1)
+	serve := func() {
+		logrus.Infof("listening on %s", socketAddress)
+		served <- h.Serve(l)
+	}
+	// With EARLY_LISTEN Docker gets retryable errors during startup;
+	// otherwise the socket does not accept requests until the driver is
+	// ready.
+	early := envBool("EARLY_LISTEN", false)
+	if early {
+		go serve()
+	}
+	d.startup()
+	ready.Store(true)
+	sdNotify("READY=1")
+	logrus.Info("driver ready")
+	go runSelftest()
+	if !early {
+		go serve()
+	}
+	logrus.Error(<-served)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minBackupMeta is the shortest backup-meta interval the CE client
+	// accepts; 0 disables the backups.
+	minBackupMeta = 5 * time.Minute
+	// backupLogBytes is how much of the end of a client log is searched for
+	// the last backup.
+	backupLogBytes = 256 << 10
+	// backupLogMessage is logged by the CE client after a metadata backup.
+	backupLogMessage = "backup metadata succeed"
+)
+
+// metaBackups remembers the last metadata backup seen per volume, as the
+// client log it is read from is rotated. It is updated by checkMetaBackups,
+// so that the volume status does not read client logs.
+var metaBackups sync.Map
+
+// checkBackupMeta rejects backup-meta intervals the client would refuse.
+func checkBackupMeta(val string) error {
+	d, err := parseDuration(val)
+	if err != nil {
+		return fmt.Errorf("invalid backup-meta %q: must be a duration such as 1h", val)
+	}
+	if d != 0 && d < minBackupMeta {
+		return fmt.Errorf("invalid backup-meta %q: must be 0 to disable backups or at least %s", val, minBackupMeta)
+	}
+	return nil
+}
+
+// checkMetaBackups is the periodic scan of the logs of the clients of
+// mounted CE volumes for their last metadata backup. Only one client of a
+// filesystem runs the backups, so a volume mounted on several nodes reports
+// the backup on one of them.
+func (d *jfsDriver) checkMetaBackups() error {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		if isCommunityEdition(v) {
+			vols[name] = v
+		}
+	}
+	d.RUnlock()
+
+	for name, v := range vols {
+		if !isJuiceFSMountedRoot(v.Mountpoint) {
+			continue
+		}
+		path := v.Options["log"]
+		if path == "" {
+			path = volumeLogPath(v)
+		}
+		if data, err := tailFile(path, backupLogBytes); err == nil {
+			if t, ok := findMetaBackup(data); ok {
+				metaBackups.Store(name, t)
+			}
+		}
+	}
+	// Forget removed volumes.
+	metaBackups.Range(func(k, _ interface{}) bool {
+		if _, ok := vols[k.(string)]; !ok {
+			metaBackups.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+// lastMetaBackup returns when the client of a CE volume on this node last
+// backed up the metadata to the object store, as last seen in its log.
+func lastMetaBackup(v *jfsVolume) (time.Time, bool) {
+	if t, ok := metaBackups.Load(dockerName(v)); ok {
+		return t.(time.Time), true
+	}
+	return time.Time{}, false
+}
+
+// findMetaBackup returns the time of the last backup message in a client
+// log, whose lines start with the local time, e.g.
+//
+//	2024/05/01 06:00:00.123456 juicefs[42] <INFO>: backup metadata succeed, ...
+func findMetaBackup(log []byte) (time.Time, bool) {
+	i := bytes.LastIndex(log, []byte(backupLogMessage))
+	if i < 0 {
+		return time.Time{}, false
+	}
+	line := log[bytes.LastIndexByte(log[:i], '\n')+1 : i]
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04:05.000000", fields[0]+" "+fields[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
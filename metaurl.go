@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// normalizeMetaURL prepends the default redis:// scheme to a metaurl given
+// without one and brackets a bare IPv6 address, so that
+// "2001:db8::1" becomes "redis://[2001:db8::1]" instead of a URL with a
+// port of "db8::1". A bare path is the unix socket of a local Redis.
+func normalizeMetaURL(metaurl string) string {
+	if strings.Contains(metaurl, "://") {
+		return metaurl
+	}
+	if strings.HasPrefix(metaurl, "/") {
+		return "unix://" + metaurl
+	}
+	userinfo, rest := "", metaurl
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userinfo, rest = rest[:i+1], rest[i+1:]
+	}
+	host, path := rest, ""
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	if ip := net.ParseIP(host); ip != nil && strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "redis://" + userinfo + host + path
+}
+
+// metaSocket returns the socket path of a unix:// metaurl such as
+// "unix:///jfs/run/redis/redis.sock?db=1", which JuiceFS connects to as a
+// Redis server.
+func metaSocket(metaurl string) (string, bool, error) {
+	rest, ok := strings.CutPrefix(metaurl, "unix://")
+	if !ok {
+		return "", false, nil
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	path, _, _ := strings.Cut(rest, "?")
+	if !strings.HasPrefix(path, "/") {
+		return "", true, fmt.Errorf("invalid metaurl %q: expected unix:///path/to/redis.sock", redactURL(metaurl))
+	}
+	return path, true, nil
+}
+
+// checkMetaHost validates a host[:port] of a metaurl, in particular the
+// bracketed form of IPv6 addresses, e.g. "[2001:db8::1]:6379".
+func checkMetaHost(host string) error {
+	if !strings.HasPrefix(host, "[") {
+		if strings.Count(host, ":") > 1 && net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid host %q: IPv6 addresses must be bracketed, e.g. [2001:db8::1]:6379", host)
+		}
+		return nil
+	}
+	end := strings.Index(host, "]")
+	if end < 0 {
+		return fmt.Errorf("invalid host %q: missing ]", host)
+	}
+	if ip := net.ParseIP(host[1:end]); ip == nil || ip.To4() != nil {
+		return fmt.Errorf("invalid host %q: %q is not an IPv6 address", host, host[1:end])
+	}
+	if port := host[end+1:]; port != "" && !strings.HasPrefix(port, ":") {
+		return fmt.Errorf("invalid host %q: expected [ADDRESS]:PORT", host)
+	}
+	return nil
+}
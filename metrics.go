@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	usageAlertsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jfs_volume_usage_alerts_total",
+		Help: "Number of times a volume crossed its capacity or inode usage threshold.",
+	}, []string{"volume", "kind"})
+
+	usageAlertActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jfs_volume_usage_alert",
+		Help: "1 while a volume's capacity or inode usage is over its threshold.",
+	}, []string{"volume", "kind"})
+
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jfs_operation_duration_seconds",
+		Help:    "Duration of format, auth, mount, readiness wait and unmount operations.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"operation", "volume", "edition", "outcome"})
+)
+
+// observeOp records the duration and outcome of an operation on a volume,
+// and failures of the operations in recordedErrorOps as its last error,
+// which a successful mount clears. It
+// takes a pointer to the error so it can be deferred with a named result.
+func observeOp(op string, v *jfsVolume, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		outcome = "failure"
+		if slices.Contains(recordedErrorOps, op) {
+			recordError(v, op, *err)
+		}
+	} else if op == "mount" {
+		clearError(v)
+	}
+	elapsed := time.Since(start)
+	operationDuration.WithLabelValues(op, v.Name, edition(v), outcome).Observe(elapsed.Seconds())
+	statsd.timing("operation.duration", elapsed, "operation:"+op, "volume:"+v.Name, "edition:"+edition(v), "outcome:"+outcome)
+}
+
+// edition returns the JuiceFS edition label of a volume.
+func edition(v *jfsVolume) string {
+	if isCommunityEdition(v) {
+		return "ce"
+	}
+	return "ee"
+}
+
+// driverCollector exports gauges computed from the driver state at scrape
+// time.
+type driverCollector struct {
+	d *jfsDriver
+
+	defined     *prometheus.Desc
+	mounted     *prometheus.Desc
+	connections *prometheus.Desc
+	processes   *prometheus.Desc
+}
+
+func newDriverCollector(d *jfsDriver) *driverCollector {
+	return &driverCollector{
+		d:           d,
+		defined:     prometheus.NewDesc("jfs_volumes_defined", "Number of volumes known to the driver.", nil, nil),
+		mounted:     prometheus.NewDesc("jfs_volumes_mounted", "Number of volumes currently mounted.", nil, nil),
+		connections: prometheus.NewDesc("jfs_volume_connections", "Number of containers using a volume.", []string{"volume"}, nil),
+		processes:   prometheus.NewDesc("jfs_mount_processes", "Number of running juicefs client processes serving volumes.", nil, nil),
+	}
+}
+
+func (c *driverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.defined
+	ch <- c.mounted
+	ch <- c.connections
+	ch <- c.processes
+}
+
+func (c *driverCollector) Collect(ch chan<- prometheus.Metric) {
+	c.d.RLock()
+	defer c.d.RUnlock()
+
+	mounted, processes := 0, 0
+	for name, v := range c.d.volumes {
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(v.connectionCount()), name)
+		if isJuiceFSMountedRoot(v.Mountpoint) {
+			mounted++
+		}
+		if findMountProcess(v.Mountpoint) != 0 {
+			processes++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.defined, prometheus.GaugeValue, float64(len(c.d.volumes)))
+	ch <- prometheus.MustNewConstMetric(c.mounted, prometheus.GaugeValue, float64(mounted))
+	ch <- prometheus.MustNewConstMetric(c.processes, prometheus.GaugeValue, float64(processes))
+}
+
+// serveMetrics exposes /metrics, /healthz and /readyz on a TCP address
+// (METRICS_ADDR) for scrapers that cannot reach the admin socket.
+func serveMetrics(addr string, d *jfsDriver) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", d.healthz)
+	mux.HandleFunc("GET /readyz", readyz)
+	logrus.Infof("metrics listening on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// migrateOfficialState takes over the volumes recorded by the official
+// juicedata/docker-volume-juicefs plugin in the state file at path, which
+// holds the bare volume map. Volumes are converted to this driver's
+// conventions: options are translated as in upstream compatibility mode,
+// legacy option names become canonical, scheme-less metaurls
+// get their redis:// scheme and mountpoints move under this driver's root.
+// Volumes that exist already are left alone. The file is renamed to
+// path.migrated afterwards so the migration runs once.
+//
+// Both plugins keep jfs-state.json in /var/lib/docker/plugins on the host by
+// default, so path may be this driver's own state file, already loaded; its
+// volumes are then converted in place.
+func (d *jfsDriver) migrateOfficialState(path string) error {
+	if fb, ok := d.state.(*fileBackend); ok && filepath.Clean(fb.path) == filepath.Clean(path) {
+		d.Lock()
+		defer d.Unlock()
+		for _, name := range sortedKeys(d.volumes) {
+			d.volumes[name] = convertOfficialVolume(d.root, name, d.volumes[name])
+		}
+		return d.writeState()
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	volumes, _, err := decodeState(data)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %s", path, err)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	var migrated int
+	for _, name := range sortedKeys(volumes) {
+		if _, ok := d.volumes[name]; ok {
+			logrus.WithField("volume", name).Infof("not migrating volume from %s: it exists already", path)
+			continue
+		}
+		d.volumes[name] = convertOfficialVolume(d.root, name, volumes[name])
+		migrated++
+	}
+	if migrated > 0 {
+		if err := d.writeState(); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("migrated %d volumes from %s", migrated, path)
+	return os.Rename(path, path+".migrated")
+}
+
+// convertOfficialVolume converts v in place and returns it.
+func convertOfficialVolume(root, name string, v *jfsVolume) *jfsVolume {
+	v.Mountpoint = filepath.Join(root, name)
+	options := map[string]string{}
+	for k, val := range v.Options {
+		if k, val, keep := compatOption(k, val); keep {
+			options[canonicalize(k)] = val
+		}
+	}
+	v.Options = options
+	if v.Name == "" {
+		v.Name = name
+	}
+	if v.Source == "" {
+		v.Source = v.Name
+	} else if v.Source != v.Name {
+		// CE volumes; EE volumes use their name as the source.
+		v.Source = normalizeMetaURL(v.Source)
+	}
+	return v
+}
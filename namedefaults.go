@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// nameDefault gives default options to the volumes whose name matches a
+// regular expression, e.g. tuning for every `^db-` volume.
+type nameDefault struct {
+	Pattern string            `json:"pattern"`
+	Options map[string]string `json:"options"`
+
+	re *regexp.Regexp
+}
+
+// compileNameDefaults compiles the patterns of the configured name
+// defaults.
+func (c *driverConfig) compileNameDefaults() error {
+	for i := range c.NameDefaults {
+		nd := &c.NameDefaults[i]
+		re, err := regexp.Compile(nd.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid name_defaults pattern %q: %s", nd.Pattern, err)
+		}
+		nd.re = re
+	}
+	return nil
+}
+
+// applyNameDefaults merges the options of every name default matching the
+// volume name underneath the options given at Create. Later entries win
+// over earlier ones.
+func (d *jfsDriver) applyNameDefaults(name string, options map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, nd := range d.config.NameDefaults {
+		if !nd.re.MatchString(name) {
+			continue
+		}
+		for k, val := range nd.Options {
+			merged[k] = val
+		}
+	}
+	if len(merged) == 0 {
+		return options
+	}
+	for k, val := range options {
+		merged[k] = val
+	}
+	return merged
+}
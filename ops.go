@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// volumeInfo is a volume as listed by the admin API.
+type volumeInfo struct {
+	Name        string                 `json:"name"`
+	Mountpoint  string                 `json:"mountpoint"`
+	Connections int                    `json:"connections"`
+	MountIDs    map[string]time.Time   `json:"mount_ids,omitempty"`
+	Status      map[string]interface{} `json:"status"`
+}
+
+// listVolumes returns every volume with its full status, regardless of the
+// listing filter.
+func (d *jfsDriver) listVolumes() []volumeInfo {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		vols[name] = v
+	}
+	d.RUnlock()
+	infos := []volumeInfo{}
+	for _, name := range sortedKeys(vols) {
+		infos = append(infos, d.volumeInfo(name, vols[name]))
+	}
+	return infos
+}
+
+// inspectVolume returns the volume called name with its full status.
+func (d *jfsDriver) inspectVolume(name string) (volumeInfo, error) {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return volumeInfo{}, err
+	}
+	return d.volumeInfo(name, v), nil
+}
+
+// volumeInfo describes v. The mounts are copied under v.mu, so a volume
+// being mounted or unmounted is described once that has finished.
+func (d *jfsDriver) volumeInfo(name string, v *jfsVolume) volumeInfo {
+	v.mu.Lock()
+	info := volumeInfo{
+		Name:        name,
+		Mountpoint:  v.Mountpoint,
+		Connections: v.connectionCount(),
+		MountIDs:    maps.Clone(v.MountIDs),
+	}
+	v.mu.Unlock()
+	d.RLock()
+	info.Status = volumeStatus(v)
+	d.addMountLimit(info.Status)
+	d.RUnlock()
+	return info
+}
+
+// lookupVolume returns the volume called name.
+func (d *jfsDriver) lookupVolume(name string) (*jfsVolume, error) {
+	d.RLock()
+	defer d.RUnlock()
+	v, ok := d.volumes[name]
+	if !ok {
+		return nil, codedError(codeVolumeNotFound, "volume %s not found", name)
+	}
+	return v, nil
+}
+
+// unmountVolume unmounts a volume on an operator's request. A volume in
+// use by containers is only unmounted with force, which also kills a
+// wedged client; the containers then lose their mount.
+func (d *jfsDriver) unmountVolume(name string, force bool) error {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return err
+	}
+	// Save the state once v.mu has been released, see Mount.
+	defer func() {
+		d.Lock()
+		d.saveState()
+		d.Unlock()
+	}()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !isJuiceFSMountedRoot(v.Mountpoint) {
+		return fmt.Errorf("volume %s is not mounted", name)
+	}
+	if v.connectionCount() > 0 && !force {
+		return codedError(codeVolumeInUse, "volume %s is used by %d containers, unmount with force to detach them", name, v.connectionCount())
+	}
+	cancelScheduledUnmount(v)
+	if force {
+		stopAccessLog(v)
+		if err := forceUmountVolume(name, v); err != nil {
+			return logError("failed to force unmount %s: %s", name, err)
+		}
+		emitEvent("unmounted", name, "forced by operator", nil)
+	} else if err := teardownVolume(name, v); err != nil {
+		return err
+	}
+	d.Lock()
+	v.connections.Store(0)
+	v.MountIDs = nil
+	d.Unlock()
+	v.pinned = false
+	return nil
+}
+
+// remountVolume unmounts a mounted volume and mounts it again, e.g. to
+// pick up changed options or recover a misbehaving client. As with
+// unmountVolume, a volume in use by containers is only remounted with
+// force, which kills a client that cannot be unmounted.
+func (d *jfsDriver) remountVolume(name string, force bool) error {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		d.Lock()
+		d.saveState()
+		d.Unlock()
+	}()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !isJuiceFSMountedRoot(v.Mountpoint) {
+		return fmt.Errorf("volume %s is not mounted", name)
+	}
+	if v.connectionCount() > 0 && !force {
+		return codedError(codeVolumeInUse, "volume %s is used by %d containers, remount with force to detach them", name, v.connectionCount())
+	}
+	cancelScheduledUnmount(v)
+	if err := umountVolume(v); err != nil {
+		if !force {
+			emitEvent("remount_failed", name, err.Error(), nil)
+			return logError("failed to remount %s: %s", name, err)
+		}
+		mountLog.Warnf("failed to umount %s, forcing: %s", name, err)
+		if err := forceUmountVolume(name, v); err != nil {
+			emitEvent("remount_failed", name, err.Error(), nil)
+			return logError("failed to remount %s: %s", name, err)
+		}
+	}
+	if err := mountVolume(v); err != nil {
+		emitEvent("remount_failed", name, err.Error(), nil)
+		return logError("failed to remount %s: %s", name, err)
+	}
+	emitEvent("remounted", name, "requested by operator", nil)
+	return nil
+}
+
+// maintenanceRequest holds the parameters of the gc, fsck and warmup
+// admin actions.
+type maintenanceRequest struct {
+	// Delete makes gc delete leaked objects instead of only counting them.
+	Delete bool `json:"delete"`
+	// Paths are the directories or files to warm up, relative to the
+	// volume root (default the whole volume).
+	Paths []string `json:"paths"`
+	// Threads is the number of warmup workers, 1 to 64.
+	Threads int `json:"threads"`
+}
+
+const maintenanceTimeout = time.Hour
+
+// maintainVolume runs `juicefs gc`, `juicefs fsck` or `juicefs warmup` for a
+// volume and returns its output. gc and fsck work on the filesystem and
+// need no mount; warmup fills the cache of the mount on this node.
+func (d *jfsDriver) maintainVolume(name, op string, r *maintenanceRequest) ([]byte, error) {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := mountOptions(v)
+	if err != nil {
+		return nil, err
+	}
+	env, err := optionEnv(opts)
+	if err != nil {
+		return nil, err
+	}
+	cli, target := eeCliPath, v.Name
+	if isCommunityEdition(v) {
+		cli, target = ceCliPath, v.Source
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	switch op {
+	case "gc":
+		cmd = exec.CommandContext(ctx, cli, "gc", target)
+		if r.Delete {
+			cmd.Args = append(cmd.Args, "--delete")
+		}
+	case "fsck":
+		cmd = exec.CommandContext(ctx, cli, "fsck", target)
+	case "warmup":
+		if !isJuiceFSMountedRoot(v.Mountpoint) {
+			return nil, fmt.Errorf("volume %s is not mounted on this node", name)
+		}
+		if r.Threads < 0 || r.Threads > 64 {
+			return nil, codedError(codeInvalidOption, "invalid threads %d: must be between 1 and 64", r.Threads)
+		}
+		cmd = exec.CommandContext(ctx, cli, "warmup")
+		if r.Threads > 0 {
+			cmd.Args = append(cmd.Args, "--threads", strconv.Itoa(r.Threads))
+		}
+		paths := r.Paths
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		for _, p := range paths {
+			full := filepath.Join(v.Mountpoint, p)
+			if full != v.Mountpoint && !strings.HasPrefix(full, v.Mountpoint+"/") {
+				return nil, codedError(codeInvalidOption, "path %q is outside of volume %s", p, name)
+			}
+			cmd.Args = append(cmd.Args, full)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %s", op)
+	}
+	cmd.Env = append(env, "JFS_NO_UPDATE=1")
+	secrets := volumeSecrets(v, opts)
+	cliLog.Debug(sanitizeOutput(cmd.String(), secrets))
+	out, err := cmd.CombinedOutput()
+	recordOutput(v, cmd, out, err, secrets)
+	out = []byte(sanitizeOutput(string(out), secrets))
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("juicefs %s for volume %s did not finish within %s", op, name, maintenanceTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("juicefs %s failed for volume %s: %s", op, name, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
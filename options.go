@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxShards bounds the `shards` format option. JuiceFS hashes block keys
+// over this many buckets, so anything larger is almost certainly a typo.
+const maxShards = 256
+
+// bucketlessStorages are the CE storage types that work without a bucket:
+// file defaults to a local directory and mem keeps the data in memory.
+var bucketlessStorages = map[string]bool{"file": true, "mem": true}
+
+// driverOptions are consumed by the plugin itself and never passed to the
+// juicefs CLI.
+var driverOptions = map[string]optionSpec{
+	"flush-timeout":         {kind: kindDuration},
+	"drain-timeout":         {kind: kindDuration},
+	"lazy-unmount":          {kind: kindBool},
+	"access-log":            {kind: kindBool},
+	"ready-verify":          {kind: kindBool},
+	"env":                   {kind: kindString},
+	"env-file":              {kind: kindString},
+	"profile":               {kind: kindString},
+	"inherit":               {kind: kindString},
+	"capacity-alert":        {kind: kindString},
+	"inode-alert":           {kind: kindString},
+	"preflight":             {kind: kindBool},
+	"async-format":          {kind: kindBool},
+	"premount":              {kind: kindBool},
+	"unmount-grace":         {kind: kindDuration},
+	"idle-unmount":          {kind: kindDuration},
+	"keep-mounted":          {kind: kindBool},
+	"mount-retries":         {kind: kindInt, min: 0, max: 100},
+	"tenant":                {kind: kindString},
+	"credential-refresh":    {kind: kindDuration},
+	"path-style":            {kind: kindBool},
+	"ceph-conf":             {kind: kindString},
+	"ceph-keyring":          {kind: kindString},
+	"azure-account":         {kind: kindString},
+	"azure-key":             {kind: kindString},
+	"azure-sas":             {kind: kindString},
+	"azure-endpoint-suffix": {kind: kindString},
+	"gcs-credentials-file":  {kind: kindString},
+	"gcs-credentials":       {kind: kindString},
+	"resolve":               {kind: kindString},
+}
+
+// isDriverOption reports whether option k is consumed by the plugin.
+func isDriverOption(k string) bool {
+	if _, ok := driverOptions[k]; ok {
+		return true
+	}
+	return strings.HasPrefix(k, labelPrefix)
+}
+
+type optionKind int
+
+const (
+	kindString optionKind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindDuration
+)
+
+// optionSpec describes the value an option accepts.
+type optionSpec struct {
+	kind optionKind
+	// min and max bound numeric values; bounds are not checked when both
+	// are zero.
+	min, max float64
+	// since is the first CE client version supporting the option.
+	since string
+}
+
+// ceMountOptions is the allowlist of typed `juicefs mount` options for CE
+// volumes. Boolean options are passed as bare flags; options not listed here
+// are passed through as --key=value.
+var ceMountOptions = map[string]optionSpec{
+	"attr-cache":         {kind: kindDuration},
+	"entry-cache":        {kind: kindDuration},
+	"dir-entry-cache":    {kind: kindDuration},
+	"open-cache":         {kind: kindDuration},
+	"backup-meta":        {kind: kindDuration},
+	"heartbeat":          {kind: kindDuration, min: 1, max: 300},
+	"io-retries":         {kind: kindInt, min: 1, max: 1000},
+	"get-timeout":        {kind: kindDuration, min: 1, max: 3600},
+	"put-timeout":        {kind: kindDuration, min: 1, max: 3600},
+	"open-cache-limit":   {kind: kindInt, min: 0, max: 1 << 30, since: "1.1.0"},
+	"readdir-cache":      {kind: kindBool, since: "1.2.0"},
+	"buffer-size":        {kind: kindInt, min: 32, max: 1 << 20},
+	"prefetch":           {kind: kindInt, min: 0, max: 1024},
+	"max-uploads":        {kind: kindInt, min: 1, max: 1024},
+	"max-deletes":        {kind: kindInt, min: 1, max: 1024},
+	"upload-delay":       {kind: kindDuration, min: 0, max: 86400},
+	"upload-limit":       {kind: kindInt, min: 0, max: 1 << 30},
+	"download-limit":     {kind: kindInt, min: 0, max: 1 << 30},
+	"cache-dir":          {kind: kindString},
+	"cache-size":         {kind: kindInt, min: 0, max: 1 << 40},
+	"free-space-ratio":   {kind: kindFloat, min: 0, max: 1},
+	"cache-partial-only": {kind: kindBool},
+	"enable-xattr":       {kind: kindBool},
+	"no-syslog":          {kind: kindBool},
+	"no-usage-report":    {kind: kindBool},
+	"no-bgjob":           {kind: kindBool},
+	"writeback":          {kind: kindBool},
+	"read-only":          {kind: kindBool},
+	"subdir":             {kind: kindString},
+}
+
+// ceFormatSpecs are the typed `juicefs format` options. The others in
+// ceFormatOptions are passed on as given. capacity is in GiB; like the other
+// format options they only take effect when the filesystem is created.
+var ceFormatSpecs = map[string]optionSpec{
+	"hash-prefix":   {kind: kindBool},
+	"capacity":      {kind: kindInt, min: 0, max: 1 << 40},
+	"inodes":        {kind: kindInt, min: 0, max: 1 << 62},
+	"storage-class": {kind: kindString, since: "1.1.0"},
+}
+
+// addFormatOptions routes the configured options to `juicefs format`, so
+// format flags of newer clients can be used before the plugin knows them.
+// Options the plugin already passes to the mount or handles itself cannot
+// be rerouted.
+func addFormatOptions(names []string) error {
+	for _, k := range names {
+		if !optionKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid format option name %q", k)
+		}
+		if _, ok := driverOptions[k]; ok {
+			return fmt.Errorf("format option %s is an option of the plugin", k)
+		}
+		if _, ok := ceMountOptions[k]; ok {
+			return fmt.Errorf("format option %s is a mount option", k)
+		}
+		if canonicalize(k) != k {
+			return fmt.Errorf("format option %s is an alias of %s", k, canonicalize(k))
+		}
+		if !slices.Contains(ceFormatOptions, k) {
+			ceFormatOptions = append(ceFormatOptions, k)
+		}
+	}
+	return nil
+}
+
+// mountFlags are boolean client flags by edition.
+type mountFlags struct {
+	CE []string `json:"ce"`
+	EE []string `json:"ee"`
+}
+
+// addMountFlags makes the configured options boolean flags of `juicefs
+// mount`, passed as a bare --flag rather than --flag=value, so flags of
+// newer clients can be used before the plugin knows them.
+func addMountFlags(flags mountFlags) error {
+	check := func(k string) error {
+		if !optionKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid mount flag name %q", k)
+		}
+		if _, ok := driverOptions[k]; ok {
+			return fmt.Errorf("mount flag %s is an option of the plugin", k)
+		}
+		if slices.Contains(ceFormatOptions, k) {
+			return fmt.Errorf("mount flag %s is a format option", k)
+		}
+		if canonicalize(k) != k {
+			return fmt.Errorf("mount flag %s is an alias of %s", k, canonicalize(k))
+		}
+		return nil
+	}
+	for _, k := range flags.CE {
+		if err := check(k); err != nil {
+			return err
+		}
+		if spec, ok := ceMountOptions[k]; ok && spec.kind != kindBool {
+			return fmt.Errorf("mount flag %s is a mount option with a value", k)
+		}
+		if _, ok := ceMountOptions[k]; !ok {
+			ceMountOptions[k] = optionSpec{kind: kindBool}
+		}
+	}
+	for _, k := range flags.EE {
+		if err := check(k); err != nil {
+			return err
+		}
+		if !isEEMountFlag(k) {
+			eeMountFlags = append(eeMountFlags, k)
+		}
+	}
+	return nil
+}
+
+// checkFormatVersions verifies that the bundled client supports the format
+// options of a CE volume.
+func checkFormatVersions(opts map[string]string) error {
+	version, err := ceVersion()
+	if err != nil {
+		// Logged by the mount option checks.
+		return nil
+	}
+	for k, spec := range ceFormatSpecs {
+		if _, ok := opts[k]; !ok {
+			continue
+		}
+		if err := checkOptionVersion(k, spec, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eeMountOptions are the typed `juicefs mount` options of EE volumes. Like
+// for CE, options not listed here are passed through as --key=value.
+var eeMountOptions = map[string]optionSpec{
+	"max-uploads":  ceMountOptions["max-uploads"],
+	"max-deletes":  ceMountOptions["max-deletes"],
+	"upload-delay": ceMountOptions["upload-delay"],
+}
+
+// ceMountDefaults are the CE flags the plugin settings of the same name turn
+// on for every mount, e.g. in air-gapped environments. A volume opts out
+// with the flag set to false. The EE client has no such flags: it talks to
+// the JuiceFS console serving its metadata anyway, and the background jobs
+// of a filesystem are configured there.
+var ceMountDefaults = map[string]string{
+	"no-bgjob":        "NO_BGJOB",
+	"no-usage-report": "NO_USAGE_REPORT",
+}
+
+// withMountDefaults returns the `juicefs mount` options of a CE volume with
+// the enabled plugin-level defaults added.
+func withMountDefaults(options map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, val := range options {
+		merged[k] = val
+	}
+	for flag, setting := range ceMountDefaults {
+		if _, ok := merged[flag]; !ok && envBool(setting, false) {
+			merged[flag] = "true"
+		}
+	}
+	return merged
+}
+
+// checkOptionValue verifies that val is acceptable for spec.
+func checkOptionValue(key, val string, spec optionSpec) error {
+	var n float64
+	switch spec.kind {
+	case kindString:
+		return nil
+	case kindBool:
+		if val == "" {
+			return nil
+		}
+		if _, err := strconv.ParseBool(val); err != nil {
+			return fmt.Errorf("invalid %s %q: must be a boolean", key, val)
+		}
+		return nil
+	case kindInt:
+		i, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: must be an integer", key, val)
+		}
+		n = float64(i)
+	case kindFloat:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: must be a number", key, val)
+		}
+		n = f
+	case kindDuration:
+		// The client accepts plain seconds as well as Go durations.
+		d, err := parseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: must be a duration such as 1s or 1.5", key, val)
+		}
+		n = d.Seconds()
+	}
+	if (spec.min != 0 || spec.max != 0) && (n < spec.min || n > spec.max) {
+		return fmt.Errorf("invalid %s %q: must be between %g and %g", key, val, spec.min, spec.max)
+	}
+	return nil
+}
+
+// checkOptionVersion verifies that the detected client supports the option.
+func checkOptionVersion(key string, spec optionSpec, have cliVersion) error {
+	if spec.since == "" {
+		return nil
+	}
+	want, err := parseCLIVersion(spec.since)
+	if err != nil {
+		return err
+	}
+	if have.less(want) {
+		return fmt.Errorf("option %s requires juicefs %s or newer, bundled client is %s", key, want, have)
+	}
+	return nil
+}
+
+// isFlagEnabled reports whether a boolean option given as key=val enables
+// the flag. A bare key (empty value) counts as enabled.
+func isFlagEnabled(val string) bool {
+	if val == "" {
+		return true
+	}
+	b, _ := strconv.ParseBool(val)
+	return b
+}
+
+// validateOptions checks option values and combinations at Create time so
+// misconfigurations are reported immediately instead of failing deep inside
+// `juicefs format` or `juicefs mount`.
+func validateOptions(v *jfsVolume) error {
+	expanded, err := expandOptions(v)
+	if err != nil {
+		return logError("invalid option template: %s", err)
+	}
+	opts := map[string]string{}
+	for k, val := range expanded {
+		opts[canonicalize(k)] = val
+	}
+
+	if isCommunityEdition(v) {
+		if _, err := metaHosts(v.Source); err != nil {
+			return logError("%s", err)
+		}
+		if _, _, err := metaSocket(v.Source); err != nil {
+			return logError("%s", err)
+		}
+	}
+
+	shards := 0
+	if val, ok := opts["shards"]; ok {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 || n > maxShards {
+			return logError("invalid shards %q: must be an integer between 0 and %d", val, maxShards)
+		}
+		shards = n
+	}
+
+	bucket, hasBucket := opts["bucket"]
+	templated := strings.Contains(bucket, "%d")
+	if templated && strings.Count(bucket, "%") != 1 {
+		return logError("invalid bucket %q: a sharded bucket must contain exactly one %%d and no other %% verbs", bucket)
+	}
+	if shards > 1 {
+		if !hasBucket {
+			return logError("shards=%d requires a bucket template containing %%d", shards)
+		}
+		if !templated {
+			return logError("shards=%d requires bucket %q to contain %%d, e.g. https://mybucket-%%d.s3.amazonaws.com", shards, bucket)
+		}
+	} else if templated {
+		return logError("bucket %q contains %%d but shards is not greater than 1", bucket)
+	}
+
+	// EE keeps the storage configuration in the console, so only CE volumes
+	// need a bucket to go with an explicit storage type.
+	if storage, ok := opts["storage"]; ok && !bucketlessStorages[storage] && !hasBucket && isCommunityEdition(v) {
+		return logError("storage=%s requires the bucket option", storage)
+	}
+
+	if err := checkCephOptions(opts); err != nil {
+		return logError("%s", err)
+	}
+	if err := checkAzureOptions(opts); err != nil {
+		return logError("%s", err)
+	}
+	if err := checkGCSOptions(opts); err != nil {
+		return logError("%s", err)
+	}
+	if val, ok := opts["resolve"]; ok {
+		if _, err := parseResolve(val); err != nil {
+			return logError("%s", err)
+		}
+	}
+
+	if s3Storages[opts["storage"]] && hasBucket && isCommunityEdition(v) {
+		var pathStyle *bool
+		if val, ok := opts["path-style"]; ok {
+			b := isFlagEnabled(val)
+			pathStyle = &b
+		}
+		// A sharded bucket is checked with its first shard.
+		if err := checkBucketURL(opts["storage"], strings.Replace(bucket, "%d", "0", 1), pathStyle); err != nil {
+			return logError("%s", err)
+		}
+	}
+
+	for k, val := range opts {
+		spec, ok := driverOptions[k]
+		if !ok {
+			continue
+		}
+		if err := checkOptionValue(k, val, spec); err != nil {
+			return logError("%s", err)
+		}
+	}
+
+	for _, kind := range []string{"capacity", "inode"} {
+		if val, ok := opts[kind+"-alert"]; ok {
+			if _, err := parseThreshold(val, kind == "capacity"); err != nil {
+				return logError("invalid %s-alert: %s", kind, err)
+			}
+		}
+	}
+
+	if val, ok := opts["backup-meta"]; ok && isCommunityEdition(v) {
+		if err := checkBackupMeta(val); err != nil {
+			return logError("%s", err)
+		}
+	}
+
+	if path := opts["env-file"]; path != "" {
+		if _, err := readEnvFile(path); err != nil {
+			return logError("%s", err)
+		}
+	}
+
+	typed := []map[string]optionSpec{ceMountOptions, ceFormatSpecs}
+	if !isCommunityEdition(v) {
+		typed = []map[string]optionSpec{eeMountOptions}
+	}
+	for k, val := range opts {
+		for _, specs := range typed {
+			spec, ok := specs[k]
+			if !ok {
+				continue
+			}
+			if err := checkOptionValue(k, val, spec); err != nil {
+				return logError("%s", err)
+			}
+		}
+	}
+
+	if err := checkConflicts(v, opts); err != nil {
+		return logError("%s", err)
+	}
+	// Checked at create time only: the option is harmless on volumes that
+	// predate the check.
+	if val, ok := opts["writeback"]; !(ok && isFlagEnabled(val)) {
+		if _, ok := opts["upload-delay"]; ok {
+			return logError("option upload-delay requires writeback: without it blocks are uploaded before writes return")
+		}
+	}
+
+	for _, pair := range [][2]string{{"access-key", "secret-key"}, {"access-key2", "secret-key2"}} {
+		_, hasAccess := opts[pair[0]]
+		_, hasSecret := opts[pair[1]]
+		if hasAccess != hasSecret {
+			return logError("options %s and %s must be given together", pair[0], pair[1])
+		}
+	}
+	if _, ok := opts["session-token"]; ok {
+		if _, ok := opts["access-key"]; !ok {
+			return logError("option session-token requires access-key and secret-key")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orphanCheckTimeout bounds the check of one volume; a meta engine that does
+// not answer within it counts as unreachable.
+const orphanCheckTimeout = 30 * time.Second
+
+// Results of the orphan check of a volume.
+const (
+	orphanOK          = "ok"
+	orphanUnreachable = "unreachable"
+	orphanMissing     = "missing"
+	// orphanUnformatted is a filesystem the plugin never formatted, e.g.
+	// of a volume that was created but never mounted; it is formatted on
+	// the first mount.
+	orphanUnformatted = "unformatted"
+)
+
+// orphanCheck is the result of checking that a volume's filesystem still
+// exists.
+type orphanCheck struct {
+	Volume  string    `json:"volume"`
+	Checked time.Time `json:"checked"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// orphanChecks are the last check results by volume.
+var orphanChecks sync.Map
+
+// checkOrphans is the periodic check for volumes whose meta engine is
+// unreachable or whose filesystem no longer exists, which would otherwise
+// only show when a container tries to mount them. Only CE volumes that are
+// not mounted on this node are checked: a mounted volume is watched by the
+// health checks, and EE volumes are managed in the JuiceFS console.
+func (d *jfsDriver) checkOrphans() error {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		if isCommunityEdition(v) && v.connectionCount() == 0 {
+			vols[name] = v
+		}
+	}
+	d.RUnlock()
+
+	for _, name := range sortedKeys(vols) {
+		v := vols[name]
+		if isJuiceFSMountedRoot(v.Mountpoint) {
+			orphanChecks.Delete(name)
+			continue
+		}
+		c := checkOrphan(name, v)
+		if prev, ok := orphanChecks.Load(name); failedOrphanCheck(c) && (!ok || prev.(orphanCheck).Status != c.Status) {
+			monitorLog.WithField("volume", name).Warnf("filesystem is %s: %s", c.Status, c.Error)
+		}
+		orphanChecks.Store(name, c)
+	}
+	// Forget removed volumes.
+	orphanChecks.Range(func(k, _ interface{}) bool {
+		d.RLock()
+		_, ok := d.volumes[k.(string)]
+		d.RUnlock()
+		if !ok {
+			orphanChecks.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+// checkOrphan runs `juicefs status` for a CE volume.
+func checkOrphan(name string, v *jfsVolume) orphanCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), orphanCheckTimeout)
+	defer cancel()
+	c := orphanCheck{Volume: name, Checked: time.Now().UTC(), Status: orphanOK}
+	if _, err := ceStatus(ctx, v); err != nil {
+		c.Status, c.Error = orphanUnreachable, err.Error()
+		if strings.Contains(err.Error(), "not formatted") {
+			c.Status = orphanMissing
+			if !v.formatted.Load() {
+				c.Status = orphanUnformatted
+			}
+		}
+	}
+	return c
+}
+
+// failedOrphanCheck reports whether c found a problem. A filesystem that
+// was never formatted is not one.
+func failedOrphanCheck(c orphanCheck) bool {
+	return c.Status != orphanOK && c.Status != orphanUnformatted
+}
+
+// loadFormatted restores which volumes were formatted from the state.
+func loadFormatted(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.formatted.Store(v.Formatted)
+	}
+}
+
+// storeFormatted copies which volumes were formatted into the volumes to be
+// saved with them. The caller holds d's lock.
+func storeFormatted(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.Formatted = v.formatted.Load()
+	}
+}
+
+// orphans returns the last check results of volumes that failed it.
+func orphans() []orphanCheck {
+	list := []orphanCheck{}
+	orphanChecks.Range(func(_, val interface{}) bool {
+		if c := val.(orphanCheck); failedOrphanCheck(c) {
+			list = append(list, c)
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].Volume < list[j].Volume })
+	return list
+}
+
+// addOrphanStatus adds the result of the last orphan check of a volume.
+func addOrphanStatus(status map[string]interface{}, name string) {
+	val, ok := orphanChecks.Load(name)
+	if !ok {
+		return
+	}
+	c := val.(orphanCheck)
+	status["filesystem"] = c.Status
+	status["filesystem_checked"] = c.Checked
+	if c.Error != "" {
+		status["filesystem_error"] = c.Error
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// admissionPolicy restricts the options developers may request. It is
+// evaluated at Create on the final options of a volume, after profiles,
+// inheritance and tenant defaults have been applied.
+type admissionPolicy struct {
+	// AllowedStorages lists the permitted `storage` types; CE volumes
+	// without one use "file".
+	AllowedStorages []string `json:"allowed_storages"`
+	// AllowedBuckets are glob patterns (path.Match) a `bucket` must match.
+	AllowedBuckets []string `json:"allowed_buckets"`
+	// ForbiddenOptions may not be set at all, e.g. "allow-root".
+	ForbiddenOptions []string `json:"forbidden_options"`
+	// RequiredOptions must be set. A non-empty constraint is either a
+	// numeric comparison (">=7", "<100", "=1") or a value the option must
+	// equal.
+	RequiredOptions map[string]string `json:"required_options"`
+}
+
+// admit rejects a volume violating the policy.
+func (p *admissionPolicy) admit(v *jfsVolume) error {
+	opts := map[string]string{}
+	for k, val := range v.Options {
+		opts[canonicalize(k)] = val
+	}
+
+	storage, ok := opts["storage"]
+	if !ok && isCommunityEdition(v) {
+		storage, ok = "file", true
+	}
+	if ok && len(p.AllowedStorages) > 0 && !slices.Contains(p.AllowedStorages, storage) {
+		return fmt.Errorf("storage %q is not allowed (allowed: %s)", storage, strings.Join(p.AllowedStorages, ", "))
+	}
+
+	if bucket, ok := opts["bucket"]; ok && len(p.AllowedBuckets) > 0 {
+		allowed := false
+		for _, pattern := range p.AllowedBuckets {
+			if matched, _ := path.Match(pattern, bucket); matched {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("bucket %q is not allowed", bucket)
+		}
+	}
+
+	for _, k := range p.ForbiddenOptions {
+		if _, ok := opts[canonicalize(k)]; ok {
+			return fmt.Errorf("option %s is not allowed", k)
+		}
+	}
+
+	for _, k := range sortedKeys(p.RequiredOptions) {
+		val, ok := opts[canonicalize(k)]
+		if !ok {
+			return fmt.Errorf("option %s is required", k)
+		}
+		if err := checkConstraint(k, val, p.RequiredOptions[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkConstraint checks an option value against a required_options
+// constraint.
+func checkConstraint(k, val, constraint string) error {
+	if constraint == "" {
+		return nil
+	}
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		bound, ok := strings.CutPrefix(constraint, op)
+		if !ok {
+			continue
+		}
+		want, err := strconv.ParseFloat(bound, 64)
+		if err != nil {
+			// Not a number: "=value" compares strings.
+			if op == "=" && val == bound {
+				return nil
+			}
+			break
+		}
+		got, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("option %s must be a number %s", k, constraint)
+		}
+		var satisfied bool
+		switch op {
+		case ">=":
+			satisfied = got >= want
+		case "<=":
+			satisfied = got <= want
+		case ">":
+			satisfied = got > want
+		case "<":
+			satisfied = got < want
+		case "=":
+			satisfied = got == want
+		}
+		if !satisfied {
+			return fmt.Errorf("option %s=%s violates the policy %s%s", k, val, k, constraint)
+		}
+		return nil
+	}
+	if val != strings.TrimPrefix(constraint, "=") {
+		return fmt.Errorf("option %s must be %s", k, strings.TrimPrefix(constraint, "="))
+	}
+	return nil
+}
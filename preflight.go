@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// preflightTimeout bounds each connectivity check done at Create.
+const preflightTimeout = 5 * time.Second
+
+// defaultMetaPorts are the ports meta engines listen on when the metaurl
+// does not name one.
+var defaultMetaPorts = map[string]string{
+	"redis":    "6379",
+	"rediss":   "6379",
+	"mysql":    "3306",
+	"postgres": "5432",
+	"tikv":     "2379",
+	"etcd":     "2379",
+}
+
+// preflight checks at Create that what the volume depends on is reachable,
+// so a typo fails `docker volume create` instead of the first container
+// start. It is skipped with `preflight=false`.
+func preflight(v *jfsVolume) error {
+	if val, ok := v.Options["preflight"]; ok && !isFlagEnabled(val) {
+		return nil
+	}
+	if isCommunityEdition(v) {
+		if err := checkMetaReachable(v.Source); err != nil {
+			return codedError(codeMetaUnreachable, "meta engine of volume %s is not reachable: %s (set preflight=false to skip this check)", v.Name, err)
+		}
+	}
+	opts, err := mountOptions(v)
+	if err != nil {
+		return logError("%s", err)
+	}
+	if err := checkSubdirFormatted(v, opts); err != nil {
+		return codedError(codeInvalidOption, "%s (set preflight=false to skip this check)", err)
+	}
+	if err := checkBucketAccess(opts); err != nil {
+		return codedError(codeBucketUnreachable, "bucket of volume %s is not accessible: %s (set preflight=false to skip this check)", v.Name, err)
+	}
+	return nil
+}
+
+// s3Storages are the storage types whose buckets speak the S3 API.
+var s3Storages = map[string]bool{"s3": true, "minio": true}
+
+// checkBucketAccess sends a signed HEAD request for an S3 compatible bucket
+// given with explicit keys. For buckets without keys (e.g. using an
+// instance role) only the endpoint is dialed. Other storage types and
+// sharded buckets are not checked.
+func checkBucketAccess(options map[string]string) error {
+	opts := map[string]string{}
+	for k, val := range options {
+		opts[canonicalize(k)] = val
+	}
+	bucket, accessKey, secretKey := opts["bucket"], opts["access-key"], opts["secret-key"]
+	if !s3Storages[opts["storage"]] || bucket == "" || strings.Contains(bucket, "%d") {
+		return nil
+	}
+	u, err := url.Parse(bucket)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid bucket URL %q", bucket)
+	}
+	if accessKey == "" || secretKey == "" {
+		if err := checkEndpointReachable(u); err != nil {
+			return fmt.Errorf("endpoint %s is not reachable: %s", u.Host, err)
+		}
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	// Temporary credentials are only valid with their session token. Like
+	// the keys, it has been resolved by mountOptions.
+	creds := awsCredentials{AccessKey: accessKey, SecretKey: secretKey, SessionToken: optionValue(opts, "session-token")}
+	signV4(req, nil, creds, bucketRegion(u.Host), "s3", time.Now())
+	client := &http.Client{Timeout: preflightTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusForbidden:
+		return fmt.Errorf("access denied to %s, check access-key and secret-key", bucket)
+	case http.StatusNotFound:
+		return fmt.Errorf("bucket %s does not exist", bucket)
+	case http.StatusMovedPermanently:
+		return fmt.Errorf("bucket %s is in region %s", bucket, resp.Header.Get("X-Amz-Bucket-Region"))
+	default:
+		return fmt.Errorf("HEAD %s returned %s", bucket, resp.Status)
+	}
+}
+
+// bucketRegion guesses the signing region from an AWS endpoint such as
+// "mybucket.s3.us-east-2.amazonaws.com". Other endpoints use us-east-1,
+// which MinIO and most S3 compatible stores accept.
+func bucketRegion(host string) string {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if label == "s3" && i+1 < len(labels) && labels[i+1] != "amazonaws" {
+			return labels[i+1]
+		}
+		if r, ok := strings.CutPrefix(label, "s3-"); ok && r != "accelerate" && r != "external-1" {
+			return r
+		}
+	}
+	return "us-east-1"
+}
+
+// checkMetaReachable dials the meta engine of a metaurl. Engines without a
+// network address, such as sqlite3 or badger, always pass.
+func checkMetaReachable(metaurl string) error {
+	if path, ok, err := metaSocket(metaurl); ok {
+		if err != nil {
+			return err
+		}
+		conn, err := net.DialTimeout("unix", path, preflightTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	hosts, err := metaHosts(metaurl)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, host := range hosts {
+		conn, err := net.DialTimeout("tcp", host, preflightTimeout)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// metaHosts returns the host:port addresses of a metaurl, e.g.
+// "redis://:pass@redis:6379/1", "mysql://user:pass@(db:3306)/juicefs",
+// "tikv://pd1:2379,pd2:2379/jfs" or "redis://[2001:db8::1]:6379/1".
+func metaHosts(metaurl string) ([]string, error) {
+	scheme, rest, ok := strings.Cut(metaurl, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid metaurl %q", redactURL(metaurl))
+	}
+	port, ok := defaultMetaPorts[scheme]
+	if !ok {
+		return nil, nil
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		rest = rest[:i]
+	}
+	rest = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(rest, "tcp"), "("), ")")
+	var hosts []string
+	for _, host := range strings.Split(rest, ",") {
+		if host == "" {
+			continue
+		}
+		if err := checkMetaHost(host); err != nil {
+			return nil, err
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no host in metaurl %q", redactURL(metaurl))
+	}
+	return hosts, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+// isPremount reports whether the volume is mounted at Create rather than
+// at first container start.
+func isPremount(v *jfsVolume) bool {
+	val, ok := v.Options["premount"]
+	return ok && isFlagEnabled(val)
+}
+
+// premount mounts the volume and holds a connection on it, so it stays
+// mounted without containers until it is removed. mounted is the number of
+// volumes currently mounted on the node.
+func premount(name string, v *jfsVolume, mounted int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.pinned {
+		return nil
+	}
+	if v.connectionCount() == 0 && !isJuiceFSMountedRoot(v.Mountpoint) {
+		if err := checkMountLimit(name, mounted); err != nil {
+			return logError("%s", err)
+		}
+		if err := mountVolume(v); err != nil {
+			emitEvent("mount_failed", name, err.Error(), nil)
+			return logError("failed to premount %s: %s", name, err)
+		}
+		emitEvent("mounted", name, "", nil)
+	}
+	v.pinned = true
+	v.connections.Add(1)
+	return nil
+}
+
+// releasePremount drops the connection held by premount and unmounts the
+// volume if no container uses it. The caller holds v.mu.
+func releasePremount(name string, v *jfsVolume) error {
+	if !v.pinned {
+		return nil
+	}
+	if v.connectionCount() == 1 {
+		if err := teardownVolume(name, v); err != nil {
+			return err
+		}
+	}
+	v.pinned = false
+	v.connections.Add(-1)
+	return nil
+}
+
+// premountAll mounts the premount volumes again after the plugin restarts.
+func (d *jfsDriver) premountAll() {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		if isPremount(v) {
+			vols[name] = v
+		}
+	}
+	d.RUnlock()
+
+	for _, name := range sortedKeys(vols) {
+		d.RLock()
+		mounted := d.mountedVolumes()
+		d.RUnlock()
+		if err := premount(name, vols[name], mounted); err != nil {
+			mountLog.WithField("volume", name).Warn(err)
+		}
+	}
+}
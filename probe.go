@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// readyVerify reports whether the readiness probe reads its marker back,
+// which also exercises the read path through the client.
+func readyVerify(v *jfsVolume) bool {
+	if val, ok := v.Options["ready-verify"]; ok {
+		return isFlagEnabled(val)
+	}
+	return envBool("READY_VERIFY", false)
+}
+
+// probeWritable creates the marker file, writes to it and removes it again.
+// With verify the content is read back and compared first.
+func probeWritable(marker string, verify bool) error {
+	content := []byte(marker + "\n")
+	f, err := os.OpenFile(marker, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(marker); err != nil {
+			mountLog.Warnf("failed to remove readiness marker %s: %s", marker, err)
+		}
+	}()
+	_, err = f.Write(content)
+	// Close reports write errors of clients that buffer writes.
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if !verify {
+		return nil
+	}
+	got, err := os.ReadFile(marker)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, content) {
+		return fmt.Errorf("readiness marker %s read back %d bytes that differ from the %d written", marker, len(got), len(content))
+	}
+	return nil
+}
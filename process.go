@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findMountProcess returns the PID of the juicefs client serving mountpoint,
+// or 0 if there is none. It scans /proc for a juicefs (or jfsmount) command
+// line that mounts the given path.
+func findMountProcess(mountpoint string) int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if isMountCommand(bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}), mountpoint) {
+			return pid
+		}
+	}
+	return 0
+}
+
+func isMountCommand(args [][]byte, mountpoint string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd := filepath.Base(string(args[0]))
+	if !strings.Contains(cmd, "juicefs") && !strings.Contains(cmd, "jfsmount") && !strings.HasPrefix(cmd, "python") {
+		return false
+	}
+	mount, target := false, false
+	for _, a := range args[1:] {
+		switch string(a) {
+		case "mount":
+			mount = true
+		case mountpoint:
+			target = true
+		}
+	}
+	return target && (mount || strings.Contains(cmd, "jfsmount"))
+}
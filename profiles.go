@@ -0,0 +1,91 @@
+package main
+
+import "github.com/sirupsen/logrus"
+
+// builtinProfile is a workload profile shipped with the plugin, with
+// options for each edition. Profiles of the same name in the configuration
+// file replace them.
+type builtinProfile struct {
+	ce, ee map[string]string
+}
+
+var builtinProfiles = map[string]builtinProfile{
+	// Small random I/O that must see other clients' changes quickly: short
+	// metadata caching, no readahead, a large local cache and no writeback.
+	"database": {
+		ce: map[string]string{
+			"attr-cache":      "1",
+			"entry-cache":     "1",
+			"dir-entry-cache": "1",
+			"open-cache":      "0",
+			"prefetch":        "0",
+			"buffer-size":     "300",
+			"cache-size":      "20480",
+		},
+		ee: map[string]string{
+			"prefetch":    "0",
+			"buffer-size": "300",
+			"cache-size":  "20480",
+		},
+	},
+	// Large sequential writes: writeback, many parallel uploads and a
+	// large buffer; reads are rare, so no readahead.
+	"backup": {
+		ce: map[string]string{
+			"writeback":   "true",
+			"buffer-size": "1024",
+			"max-uploads": "50",
+			"prefetch":    "0",
+		},
+		ee: map[string]string{
+			"buffer-size": "1024",
+			"max-uploads": "50",
+			"prefetch":    "0",
+		},
+	},
+	// Training data read many times and rarely changed: long metadata
+	// caching, readahead and a large local cache.
+	"ml-read-heavy": {
+		ce: map[string]string{
+			"attr-cache":       "60",
+			"entry-cache":      "60",
+			"dir-entry-cache":  "60",
+			"open-cache":       "60",
+			"open-cache-limit": "100000",
+			"readdir-cache":    "true",
+			"prefetch":         "3",
+			"buffer-size":      "1024",
+			"cache-size":       "102400",
+		},
+		ee: map[string]string{
+			"prefetch":    "3",
+			"buffer-size": "1024",
+			"cache-size":  "102400",
+		},
+	},
+}
+
+// builtinProfileOptions returns the options of built-in profile name for
+// the edition the create options select. CE options the bundled client is
+// too old for are left out.
+func builtinProfileOptions(name string, options map[string]string) (map[string]string, bool) {
+	p, ok := builtinProfiles[name]
+	if !ok {
+		return nil, false
+	}
+	if optionValue(options, "metaurl") == "" {
+		return p.ee, true
+	}
+	version, verErr := ceVersion()
+	profile := map[string]string{}
+	for k, val := range p.ce {
+		if spec := ceMountOptions[k]; verErr == nil {
+			if err := checkOptionVersion(k, spec, version); err != nil {
+				logrus.Debugf("profile %s: %s", name, err)
+				continue
+			}
+		}
+		profile[k] = val
+	}
+	return profile, true
+}
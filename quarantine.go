@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// quarantine is recorded in the state of a volume that failed to mount too
+// often. Mounts fail fast until it expires or an admin resets it, so a
+// broken volume does not keep hammering its meta engine or the console.
+type quarantine struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// quarantineSettings returns how many failed mounts (QUARANTINE_FAILURES,
+// default 5, 0 disables quarantine) within QUARANTINE_WINDOW (default 10m)
+// quarantine a volume, and for how long (QUARANTINE_COOLDOWN, default 30m).
+func quarantineSettings() (int, time.Duration, time.Duration) {
+	return envInt("QUARANTINE_FAILURES", 5),
+		envDuration("QUARANTINE_WINDOW", 10*time.Minute),
+		envDuration("QUARANTINE_COOLDOWN", 30*time.Minute)
+}
+
+// checkQuarantine fails while the volume is quarantined. It lifts an
+// expired quarantine and reports that the state changed. The caller holds
+// v.mu.
+func checkQuarantine(name string, v *jfsVolume) (bool, error) {
+	q := v.quarantine.Load()
+	if q == nil {
+		return false, nil
+	}
+	if time.Now().Before(q.Until) {
+		return false, fmt.Errorf("volume %s is quarantined until %s after repeated mount failures (last: %s); reset it with the admin API or wait for the cool-down",
+			name, q.Until.Format(time.RFC3339), q.Reason)
+	}
+	mountLog.WithField("volume", name).Info("quarantine expired")
+	v.quarantine.Store(nil)
+	v.failures = nil
+	return true, nil
+}
+
+// recordMountFailure counts a failed mount and quarantines the volume when
+// it failed too often within the window. It reports whether the volume was
+// quarantined. The caller holds v.mu.
+func recordMountFailure(name string, v *jfsVolume, err error) bool {
+	limit, window, cooldown := quarantineSettings()
+	if limit <= 0 {
+		return false
+	}
+	now := time.Now()
+	recent := v.failures[:0]
+	for _, t := range v.failures {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	v.failures = append(recent, now)
+	if len(v.failures) < limit {
+		return false
+	}
+	q := &quarantine{Until: now.Add(cooldown), Reason: err.Error()}
+	v.quarantine.Store(q)
+	v.failures = nil
+	mountLog.WithField("volume", name).Warnf("quarantined until %s after %d failed mounts within %s", q.Until.Format(time.RFC3339), limit, window)
+	emitEvent("quarantined", name, err.Error(), map[string]interface{}{"until": q.Until})
+	return true
+}
+
+// resetQuarantine lifts the quarantine of a volume.
+func (d *jfsDriver) resetQuarantine(name string) error {
+	d.RLock()
+	v, ok := d.volumes[name]
+	d.RUnlock()
+	if !ok {
+		return codedError(codeVolumeNotFound, "volume %s not found", name)
+	}
+
+	v.mu.Lock()
+	v.quarantine.Store(nil)
+	v.failures = nil
+	v.mu.Unlock()
+
+	d.Lock()
+	d.saveState()
+	d.Unlock()
+	mountLog.WithField("volume", name).Info("quarantine reset")
+	return nil
+}
+
+// loadQuarantines restores the quarantines saved with the volumes.
+func loadQuarantines(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.quarantine.Store(v.Quarantine)
+	}
+}
+
+// storeQuarantines copies the quarantines into the volumes to be saved with
+// them. The caller holds d's lock.
+func storeQuarantines(volumes map[string]*jfsVolume) {
+	for _, v := range volumes {
+		v.Quarantine = v.quarantine.Load()
+	}
+}
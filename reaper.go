@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// zombieReaper reaps exited children of the plugin: the `juicefs mount -d`
+// processes, which are started and never waited for, and, since the plugin
+// is PID 1 in the managed plugin container, the daemonized clients that are
+// re-parented to it when their parent exits.
+//
+// The plugin waits for the commands it runs itself, and reaping one of
+// those first would make their Wait fail, so a zombie is only reaped when
+// it is still there one scan after it was first seen.
+type zombieReaper struct {
+	seen map[int]bool
+}
+
+func newZombieReaper() *zombieReaper {
+	return &zombieReaper{seen: map[int]bool{}}
+}
+
+func (z *zombieReaper) reap() error {
+	zombies, err := childZombies()
+	if err != nil {
+		return err
+	}
+	seen := map[int]bool{}
+	for pid, comm := range zombies {
+		if !z.seen[pid] {
+			seen[pid] = true
+			continue
+		}
+		var status unix.WaitStatus
+		if reaped, err := unix.Wait4(pid, &status, unix.WNOHANG, nil); err == nil && reaped == pid {
+			monitorLog.Debugf("reaped process %d (%s), exit status %d", pid, comm, status.ExitStatus())
+		}
+	}
+	z.seen = seen
+	return nil
+}
+
+// childZombies returns the pids and command names of the defunct children
+// of the plugin.
+func childZombies() (map[int]string, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	zombies := map[int]string{}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ...; comm may contain spaces and parentheses.
+		open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := bytes.Fields(stat[end+1:])
+		if len(fields) < 2 || string(fields[0]) != "Z" {
+			continue
+		}
+		if ppid, _ := strconv.Atoi(string(fields[1])); ppid == self {
+			zombies[pid] = string(stat[open+1 : end])
+		}
+	}
+	return zombies, nil
+}
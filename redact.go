@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// isSecretOption reports whether the value of option k must never be shown
+// in logs, status or diagnostics.
+func isSecretOption(k string) bool {
+	k = canonicalize(k)
+	return isCredentialKey(k) || storageSecrets[k]
+}
+
+// isSecretEnv reports whether an environment variable given with the `env`
+// option holds a secret, e.g. META_PASSWORD or AWS_SECRET_ACCESS_KEY.
+func isSecretEnv(key string) bool {
+	key = strings.ToUpper(key)
+	for _, fragment := range []string{"PASSWORD", "SECRET", "TOKEN", "KEY", "CREDENTIAL"} {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactOptions returns a copy of opts with secret values replaced. The
+// variables of the `env` option keep their names only.
+func redactOptions(opts map[string]string) map[string]string {
+	redacted := make(map[string]string, len(opts))
+	for k, val := range opts {
+		if isSecretOption(k) && val != "" {
+			val = "****"
+		}
+		if canonicalize(k) == "env" && val != "" {
+			vars := strings.Split(val, ",")
+			for i, kv := range vars {
+				if key, _, ok := strings.Cut(kv, "="); ok {
+					vars[i] = key + "=****"
+				}
+			}
+			val = strings.Join(vars, ",")
+		}
+		redacted[k] = val
+	}
+	return redacted
+}
+
+// redactURL hides the password of a URL such as a metaurl.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "****")
+	}
+	return u.String()
+}
+
+// redactMetaURL reduces a metaurl to its scheme and host, or path,
+// dropping credentials, database numbers and query parameters.
+func redactMetaURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "****"
+	}
+	if u.Host == "" {
+		// Unix sockets and local databases such as sqlite3 are paths.
+		return u.Scheme + "://" + u.Path
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// secretValues returns the secret values of opts for sanitizeOutput,
+// including those of secret variables given with the `env` option.
+func secretValues(opts map[string]string) []string {
+	var secrets []string
+	for k, val := range opts {
+		if isSecretOption(k) && val != "" {
+			secrets = append(secrets, val)
+		}
+	}
+	for _, kv := range strings.Split(opts["env"], ",") {
+		if key, val, ok := strings.Cut(kv, "="); ok && val != "" && isSecretEnv(key) {
+			secrets = append(secrets, val)
+		}
+	}
+	return secrets
+}
+
+// volumeSecrets returns the secret values of opts, the options of v, and the
+// password of its metaurl, which CE commands get on the command line.
+func volumeSecrets(v *jfsVolume, opts map[string]string) []string {
+	secrets := secretValues(opts)
+	if u, err := url.Parse(v.Source); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && password != "" {
+			// The command line holds the password as written in the URL.
+			_, raw, _ := strings.Cut(u.User.String(), ":")
+			secrets = append(secrets, password, raw)
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxReportErrors is the number of recent failed CLI invocations reported.
+const maxReportErrors = 20
+
+// startedAt is when the plugin process started, for the reported uptime.
+var startedAt = time.Now()
+
+// report is a consolidated, secret-free view of the plugin for support
+// tickets.
+type report struct {
+	Build        buildInfo         `json:"build"`
+	CLIVersions  map[string]string `json:"cli_versions"`
+	Started      time.Time         `json:"started"`
+	Uptime       string            `json:"uptime"`
+	Volumes      int               `json:"volumes"`
+	Mounts       []reportMount     `json:"mounts"`
+	RecentErrors []reportError     `json:"recent_errors"`
+	Jobs         []jobStatus       `json:"jobs"`
+}
+
+// reportMount is a JuiceFS mount below the volumes root.
+type reportMount struct {
+	Volume      string `json:"volume,omitempty"`
+	Mountpoint  string `json:"mountpoint"`
+	Source      string `json:"source"`
+	PID         int    `json:"pid,omitempty"`
+	Connections int    `json:"connections"`
+}
+
+// reportError is a failed CLI invocation of a volume.
+type reportError struct {
+	Time      time.Time `json:"time"`
+	Volume    string    `json:"volume"`
+	Command   string    `json:"command"`
+	Error     string    `json:"error"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// report collects the operational report. Mounts are taken from the mount
+// table rather than the state so that leftovers of unknown volumes show up.
+func (d *jfsDriver) report() *report {
+	r := &report{
+		Build:        getBuildInfo(),
+		CLIVersions:  map[string]string{},
+		Started:      startedAt.UTC(),
+		Uptime:       time.Since(startedAt).Round(time.Second).String(),
+		Mounts:       []reportMount{},
+		RecentErrors: recentErrors(maxReportErrors),
+		Jobs:         jobStatuses(),
+	}
+	for _, path := range []string{ceCliPath, eeCliPath} {
+		out, err := exec.Command(path, "version").CombinedOutput()
+		version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		if err != nil {
+			version = fmt.Sprintf("%s (%s)", version, err)
+		}
+		r.CLIVersions[path] = strings.TrimSpace(version)
+	}
+
+	type volumeRef struct {
+		name        string
+		connections int
+	}
+	d.RLock()
+	r.Volumes = len(d.volumes)
+	byMountpoint := map[string]volumeRef{}
+	for name, v := range d.volumes {
+		byMountpoint[v.Mountpoint] = volumeRef{name, v.connectionCount()}
+	}
+	d.RUnlock()
+
+	for _, m := range juicefsMounts(d.root) {
+		if ref, ok := byMountpoint[m.Mountpoint]; ok {
+			m.Volume = ref.name
+			m.Connections = ref.connections
+		}
+		m.PID = findMountProcess(m.Mountpoint)
+		r.Mounts = append(r.Mounts, m)
+	}
+	return r
+}
+
+// juicefsMounts returns the JuiceFS mounts below root from the mount table.
+// Sources are redacted since EE mounts may show tokens in them.
+func juicefsMounts(root string) []reportMount {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var mounts []reportMount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// ID parent major:minor root mountpoint options... - fstype source superoptions
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		fields, extra := strings.Fields(pre), strings.Fields(post)
+		if !ok || len(fields) < 5 || len(extra) < 2 {
+			continue
+		}
+		mountpoint := unescapeMountinfo(fields[4])
+		if !strings.Contains(extra[0], "juicefs") || !strings.HasPrefix(mountpoint, root+"/") {
+			continue
+		}
+		mounts = append(mounts, reportMount{Mountpoint: mountpoint, Source: redactURL(unescapeMountinfo(extra[1]))})
+	}
+	return mounts
+}
+
+// unescapeMountinfo decodes the octal escapes of spaces, tabs, newlines and
+// backslashes in mountinfo fields.
+func unescapeMountinfo(s string) string {
+	r := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return r.Replace(s)
+}
+
+// recentErrors returns the last n failed CLI invocations of all volumes,
+// newest first.
+func recentErrors(n int) []reportError {
+	commandOutputs.Lock()
+	errs := []reportError{}
+	for name, list := range commandOutputs.byVolume {
+		for _, rec := range list {
+			if rec.Error != "" {
+				errs = append(errs, reportError{
+					Time:      rec.Time,
+					Volume:    name,
+					Command:   rec.Command,
+					Error:     rec.Error,
+					RequestID: rec.RequestID,
+				})
+			}
+		}
+	}
+	commandOutputs.Unlock()
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Time.After(errs[j].Time) })
+	if len(errs) > n {
+		errs = errs[:n]
+	}
+	return errs
+}
+
+// writeText writes the report as plain text to paste into a ticket.
+func (r *report) writeText(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, r.Build)
+	for _, path := range sortedKeys(r.CLIVersions) {
+		fmt.Fprintf(&buf, "%s: %s\n", path, r.CLIVersions[path])
+	}
+	fmt.Fprintf(&buf, "started %s, up %s\n", r.Started.Format(time.RFC3339), r.Uptime)
+	fmt.Fprintf(&buf, "%d volumes, %d mounts\n", r.Volumes, len(r.Mounts))
+
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nVOLUME\tPID\tCONTAINERS\tMOUNTPOINT\tSOURCE")
+	for _, m := range r.Mounts {
+		volume := m.Volume
+		if volume == "" {
+			volume = "(unknown)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", volume, m.PID, m.Connections, m.Mountpoint, m.Source)
+	}
+	fmt.Fprintln(tw, "\nJOB\tINTERVAL\tRUNS\tLAST RUN\tLAST ERROR")
+	for _, j := range r.Jobs {
+		last := "never"
+		if !j.LastRun.IsZero() {
+			last = j.LastRun.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", j.Name, j.Interval, j.Runs, last, j.LastError)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(&buf, "\nRecent errors:")
+	if len(r.RecentErrors) == 0 {
+		fmt.Fprintln(&buf, "none")
+	}
+	for _, e := range r.RecentErrors {
+		fmt.Fprintf(&buf, "%s %s: %s: %s", e.Time.Format(time.RFC3339), e.Volume, e.Command, e.Error)
+		if e.RequestID != "" {
+			fmt.Fprintf(&buf, " (request %s)", e.RequestID)
+		}
+		buf.WriteByte('\n')
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
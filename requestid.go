@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Every Docker API call gets a request ID. It is passed to the driver in
+// the context of the call, recorded on the volume the call operates on and
+// from there added to the log lines and captured CLI output of the volume,
+// including work the call leaves to run in the background, such as an
+// asynchronous format or an unmount after the grace period.
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// contextRequestID returns the request ID of ctx, or "" outside of an API
+// call.
+func contextRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// traceRequest runs an API call with a new request ID in its context and
+// adds the ID to the error it returns.
+func traceRequest(fn func(ctx context.Context) error) error {
+	id := newRequestID()
+	if err := fn(context.WithValue(context.Background(), requestIDKey{}, id)); err != nil {
+		return fmt.Errorf("%w (request %s)", err, id)
+	}
+	return nil
+}
+
+// setRequest records the API call of ctx as the one operating on v.
+func (v *jfsVolume) setRequest(ctx context.Context) {
+	if id := contextRequestID(ctx); id != "" {
+		v.request.Store(&id)
+	}
+}
+
+// requestID returns the ID of the API call that last operated on v.
+func (v *jfsVolume) requestID() string {
+	if id := v.request.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// requestLog returns l with the request ID of ctx.
+func requestLog(l *logrus.Logger, ctx context.Context) *logrus.Entry {
+	return l.WithField("request_id", contextRequestID(ctx))
+}
+
+// volumeLog returns l with the name of v and the ID of the API call that
+// last operated on it.
+func volumeLog(l *logrus.Logger, v *jfsVolume) *logrus.Entry {
+	e := l.WithField("volume", dockerName(v))
+	if id := v.requestID(); id != "" {
+		e = e.WithField("request_id", id)
+	}
+	return e
+}
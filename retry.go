@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetryBackoff caps the delay between mount attempts.
+const maxRetryBackoff = 30 * time.Second
+
+// transientErrors are fragments of errors worth another mount attempt:
+// network hiccups talking to the meta engine, object storage or console,
+// and a client that was slow to come up.
+var transientErrors = []string{
+	"timeout",
+	"timed out",
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"temporary failure",
+	"try again",
+	"did not become ready",
+	"not yet a JuiceFS mount",
+}
+
+// mountRetries returns the per-volume `mount-retries` option, falling back
+// to the MOUNT_RETRIES driver setting (default 2).
+func mountRetries(v *jfsVolume) int {
+	if val, ok := v.Options["mount-retries"]; ok {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			return n
+		}
+		mountLog.Warnf("ignoring invalid mount-retries %q for volume %s", val, v.Name)
+	}
+	return envInt("MOUNT_RETRIES", 2)
+}
+
+func isTransientMountError(err error) bool {
+	msg := err.Error()
+	for _, fragment := range transientErrors {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns the delay before retry number attempt (from 1):
+// exponential from MOUNT_RETRY_BACKOFF (default 1s) with up to 50% jitter,
+// so many volumes failing together do not retry in lockstep.
+func retryBackoff(attempt int) time.Duration {
+	backoff := envDuration("MOUNT_RETRY_BACKOFF", time.Second)
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
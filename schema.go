@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// strictOptions is set with OPTIONS_MODE=strict. Create then rejects options
+// the plugin does not know instead of passing them to `juicefs mount`.
+var strictOptions bool
+
+// setOptionsMode applies the OPTIONS_MODE setting.
+func setOptionsMode(mode string) error {
+	switch mode {
+	case "", "permissive":
+	case "strict":
+		strictOptions = true
+	default:
+		return fmt.Errorf("invalid OPTIONS_MODE %q: must be permissive or strict", mode)
+	}
+	return nil
+}
+
+// isSchemaOption reports whether k is an option the plugin knows for the
+// edition of v, including the configured format options and mount flags.
+func isSchemaOption(v *jfsVolume, k string) bool {
+	k = canonicalize(k)
+	if isKnownOption(k) || isDriverOption(k) || k == "log" || k == "ro" {
+		return true
+	}
+	if isCommunityEdition(v) {
+		return false
+	}
+	if _, ok := eeMountOptions[k]; ok {
+		return true
+	}
+	return isEEMountFlag(k) || slices.Contains(eeOnlyOptions, k)
+}
+
+// schemaOptions returns the option names the plugin knows for the edition
+// of v, to suggest in place of unknown ones.
+func schemaOptions(v *jfsVolume) []string {
+	names := []string{"name", "metaurl", "log", "ro"}
+	names = append(names, sortedKeys(driverOptions)...)
+	names = append(names, sortedKeys(ceMountOptions)...)
+	names = append(names, ceFormatOptions...)
+	names = append(names, credentialKeys...)
+	if !isCommunityEdition(v) {
+		names = append(names, sortedKeys(eeMountOptions)...)
+		names = append(names, eeMountFlags...)
+		names = append(names, eeOnlyOptions...)
+	}
+	return names
+}
+
+// suggestOption returns the known option closest to the unknown option k,
+// if any is close enough to be a typo of it.
+func suggestOption(k string, known []string) (string, bool) {
+	best, bestDist := "", -1
+	for _, name := range known {
+		d := editDistance(k, name)
+		// Separators are easily dropped or mixed up: cachesize, cache_size.
+		if strings.NewReplacer("-", "", "_", "").Replace(k) == strings.ReplaceAll(name, "-", "") {
+			d = 0
+		}
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	// Allow one edit for short names and about one per four characters.
+	limit := max(1, len(k)/4)
+	if bestDist < 0 || bestDist > limit {
+		return "", false
+	}
+	return best, true
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// unknownOptionMessage describes the unknown options of v, with the known
+// option each is most likely a typo of.
+func unknownOptionMessage(v *jfsVolume, unknown []string) string {
+	known := schemaOptions(v)
+	msgs := make([]string, 0, len(unknown))
+	for _, k := range unknown {
+		msg := fmt.Sprintf("unknown option %q", k)
+		if suggestion, ok := suggestOption(k, known); ok {
+			msg += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		msgs = append(msgs, msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// unknownOptions returns the sorted options of v the plugin does not know.
+func unknownOptions(v *jfsVolume) []string {
+	var unknown []string
+	for k := range v.Options {
+		if !isSchemaOption(v, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// checkUnknownOptions rejects unknown options in strict mode; otherwise they
+// are passed through to the client with a warning.
+func checkUnknownOptions(name string, v *jfsVolume) error {
+	unknown := unknownOptions(v)
+	if len(unknown) == 0 {
+		return nil
+	}
+	msg := unknownOptionMessage(v, unknown)
+	if strictOptions {
+		return fmt.Errorf("%s", msg)
+	}
+	apiLog.WithField("volume", name).Warnf("passing to juicefs mount: %s", msg)
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretResolver resolves secret references of one scheme, such as
+// `file:///run/secrets/token`, to the secret at mount time.
+type secretResolver interface {
+	// Resolve returns the secret for the part of the reference after
+	// "scheme://".
+	Resolve(ref string) (string, error)
+}
+
+// secretResolvers maps reference schemes to their resolvers.
+var secretResolvers = map[string]secretResolver{}
+
+// registerSecretResolver makes references with the scheme resolvable.
+// Resolvers register themselves from init functions.
+func registerSecretResolver(scheme string, r secretResolver) {
+	if _, ok := secretResolvers[scheme]; ok {
+		panic("secret resolver registered twice: " + scheme)
+	}
+	secretResolvers[scheme] = r
+}
+
+func init() {
+	registerSecretResolver("file", fileResolver{})
+	registerSecretResolver("env", envResolver{})
+}
+
+// fileResolver reads `file:///path` inside the plugin. Trailing newlines
+// are removed.
+type fileResolver struct{}
+
+func (fileResolver) Resolve(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// envResolver reads `env://NAME` from the plugin environment.
+type envResolver struct{}
+
+func (envResolver) Resolve(name string) (string, error) {
+	secret, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return secret, nil
+}
+
+// cachingResolver keeps secrets fetched from remote stores in memory for
+// SECRET_CACHE_TTL (default 5m), so a burst of mounts does not hit the
+// store for every volume. Secrets are never written to disk.
+type cachingResolver struct {
+	resolver secretResolver
+
+	mu      sync.Mutex
+	entries map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+func newCachingResolver(r secretResolver) *cachingResolver {
+	return &cachingResolver{resolver: r, entries: map[string]cachedSecret{}}
+}
+
+func (c *cachingResolver) Resolve(ref string) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[ref]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+	value, err := c.resolver.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.entries[ref] = cachedSecret{value: value, expires: time.Now().Add(envDuration("SECRET_CACHE_TTL", 5*time.Minute))}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// forget drops a cached secret so the next Resolve fetches it again.
+func (c *cachingResolver) forget(ref string) {
+	c.mu.Lock()
+	delete(c.entries, ref)
+	c.mu.Unlock()
+}
+
+// parseSecretRef splits a reference into its resolver and the rest.
+func parseSecretRef(val string) (secretResolver, string, bool) {
+	scheme, ref, ok := strings.Cut(val, "://")
+	if !ok {
+		return nil, "", false
+	}
+	r, ok := secretResolvers[scheme]
+	return r, ref, ok
+}
+
+// isSecretRef reports whether an option value refers to a secret instead
+// of holding it, e.g. `secret-key=file:///run/secrets/jfs-secret-key` or
+// `token=env://JFS_TOKEN`.
+func isSecretRef(val string) bool {
+	_, _, ok := parseSecretRef(val)
+	return ok
+}
+
+// resolveSecret returns the value a secret reference points to. Values
+// that are not references are returned as they are.
+func resolveSecret(val string) (string, error) {
+	r, ref, ok := parseSecretRef(val)
+	if !ok {
+		return val, nil
+	}
+	return r.Resolve(ref)
+}
+
+// defaultSecretFileDirs are the directories file:// references of volumes
+// may read by default.
+const defaultSecretFileDirs = "/run/secrets,/jfs/state/secrets"
+
+// checkSecretRefScope rejects env:// and file:// references of a volume
+// outside of what volumes may read: variables starting with
+// SECRET_ENV_PREFIX (default JFS_) and files below SECRET_FILE_DIRS.
+// Otherwise a volume could read the plugin's own credentials or state and
+// hand them to an endpoint of its choosing.
+func checkSecretRefScope(val string) error {
+	scheme, ref, ok := strings.Cut(val, "://")
+	if !ok {
+		return nil
+	}
+	switch scheme {
+	case "env":
+		if prefix := envString("SECRET_ENV_PREFIX", "JFS_"); !strings.HasPrefix(ref, prefix) {
+			return fmt.Errorf("%s is not allowed: variables must start with %s (SECRET_ENV_PREFIX)", val, prefix)
+		}
+	case "file":
+		dirs := envString("SECRET_FILE_DIRS", defaultSecretFileDirs)
+		path := filepath.Clean(ref)
+		for _, dir := range strings.Split(dirs, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" && strings.HasPrefix(path, filepath.Clean(dir)+"/") {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not allowed: files must be in %s (SECRET_FILE_DIRS)", val, dirs)
+	}
+	return nil
+}
+
+// resolveSecrets replaces secret references in the secret options of opts
+// with their values. References are resolved at mount time only, so the
+// secrets never end up in the driver state or Docker's volume metadata.
+func resolveSecrets(opts map[string]string) error {
+	for k, val := range opts {
+		if !isSecretOption(k) || !isSecretRef(val) {
+			continue
+		}
+		if err := checkSecretRefScope(val); err != nil {
+			return fmt.Errorf("cannot resolve %s: %s", k, err)
+		}
+		secret, err := resolveSecret(val)
+		if err != nil {
+			return fmt.Errorf("cannot resolve %s: %s", k, err)
+		}
+		opts[k] = secret
+	}
+	return nil
+}
+
+// checkSecretRefs rejects references a new volume may not read, and literal
+// secrets when REQUIRE_SECRET_REFS is set.
+func checkSecretRefs(v *jfsVolume) error {
+	required := envBool("REQUIRE_SECRET_REFS", false)
+	for _, k := range sortedKeys(v.Options) {
+		val := v.Options[k]
+		if !isSecretOption(k) || val == "" {
+			continue
+		}
+		if !isSecretRef(val) {
+			if required {
+				return fmt.Errorf("option %s holds a literal secret; REQUIRE_SECRET_REFS requires a reference such as file:///run/secrets/... or env://JFS_NAME", k)
+			}
+			continue
+		}
+		if err := checkSecretRefScope(val); err != nil {
+			return fmt.Errorf("option %s: %s", k, err)
+		}
+	}
+	return nil
+}
+
+// mountOptions returns the options of a volume as the juicefs CLI gets
+// them: templates expanded and secret references resolved.
+func mountOptions(v *jfsVolume) (map[string]string, error) {
+	opts, err := expandOptions(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(opts); err != nil {
+		return nil, withCode(codeSecretUnresolved, err)
+	}
+	return opts, nil
+}
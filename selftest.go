@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// selftestMountpoint is where the self-test volume is mounted, outside the
+// volume root so that it cannot collide with a volume of the same name.
+const selftestMountpoint = "/run/jfs-selftest"
+
+// selftestResult is the outcome of the startup self-test.
+type selftestResult struct {
+	// Status is running, passed or failed.
+	Status   string     `json:"status"`
+	Step     string     `json:"step,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// selftest holds the self-test result, nil when no self-test is
+// configured.
+var selftest struct {
+	sync.Mutex
+	result *selftestResult
+}
+
+func selftestStatus() *selftestResult {
+	selftest.Lock()
+	defer selftest.Unlock()
+	if selftest.result == nil {
+		return nil
+	}
+	r := *selftest.result
+	return &r
+}
+
+// runSelftest checks with a throwaway volume on SELFTEST_METAURL, a meta
+// engine set aside for it, that the plugin can format, mount, write to,
+// read from and unmount a filesystem. The data goes to SELFTEST_BUCKET,
+// local files by default. The volume is never recorded in the state.
+func runSelftest() {
+	meta := os.Getenv("SELFTEST_METAURL")
+	if meta == "" {
+		return
+	}
+	result := &selftestResult{Status: "running", Started: time.Now().UTC()}
+	setStep := func(step string) {
+		selftest.Lock()
+		result.Step = step
+		selftest.Unlock()
+	}
+	selftest.Lock()
+	selftest.result = result
+	selftest.Unlock()
+
+	err := func() error {
+		setStep("resolve")
+		metaurl, err := resolveSecret(meta)
+		if err != nil {
+			return fmt.Errorf("cannot resolve SELFTEST_METAURL: %s", err)
+		}
+		v := &jfsVolume{
+			Name:       "jfs-selftest",
+			Source:     normalizeMetaURL(metaurl),
+			Mountpoint: selftestMountpoint,
+			Options: map[string]string{
+				"storage":         "file",
+				"bucket":          envString("SELFTEST_BUCKET", "/tmp/jfs-selftest/"),
+				"no-usage-report": "true",
+			},
+		}
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		setStep("mount")
+		if err := mountVolume(v); err != nil {
+			return err
+		}
+		setStep("write")
+		probe := filepath.Join(v.Mountpoint, fmt.Sprintf("selftest-%d", time.Now().UnixNano()))
+		content := []byte("docker-volume-juicefs self-test\n")
+		err = os.WriteFile(probe, content, 0600)
+		if err == nil {
+			setStep("read")
+			var got []byte
+			if got, err = os.ReadFile(probe); err == nil && !bytes.Equal(got, content) {
+				err = fmt.Errorf("read back %d bytes that differ from the %d written", len(got), len(content))
+			}
+			os.Remove(probe)
+		}
+		setStep("unmount")
+		if uerr := umountVolume(v); uerr != nil {
+			if err == nil {
+				err = uerr
+			}
+			lazyUmountVolume(v)
+		}
+		os.Remove(v.Mountpoint)
+		return err
+	}()
+
+	finished := time.Now().UTC()
+	selftest.Lock()
+	result.Finished = &finished
+	if err != nil {
+		result.Status, result.Error = "failed", err.Error()
+	} else {
+		result.Status, result.Step = "passed", ""
+	}
+	selftest.Unlock()
+	if err != nil {
+		logrus.Errorf("self-test failed at %s: %s", result.Step, err)
+	} else {
+		logrus.Infof("self-test passed in %s", finished.Sub(result.Started).Round(time.Millisecond))
+	}
+}
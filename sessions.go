@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// sessionTimeout bounds the `juicefs status` call recording the session of a
+// new mount, which must not hold up the mount for long.
+const sessionTimeout = 10 * time.Second
+
+// juicefsSession is a client session as reported by the CE `juicefs status`.
+// A client renews its session while it runs; a session whose expiry has
+// passed belongs to a client that crashed or a node that went away, and still
+// holds its locks and keeps deleted files from being compacted.
+type juicefsSession struct {
+	Sid        uint64    `json:"sid"`
+	Expire     time.Time `json:"expire"`
+	Version    string    `json:"version"`
+	HostName   string    `json:"hostname"`
+	MountPoint string    `json:"mountpoint"`
+	MountTime  time.Time `json:"mount_time"`
+	ProcessID  int       `json:"pid"`
+	Stale      bool      `json:"stale"`
+}
+
+// UnmarshalJSON reads the field names `juicefs status` prints.
+func (s *juicefsSession) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Sid        uint64
+		Expire     time.Time
+		Version    string
+		HostName   string
+		MountPoint string
+		MountTime  time.Time
+		ProcessID  int
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = juicefsSession{
+		Sid:        raw.Sid,
+		Expire:     raw.Expire,
+		Version:    raw.Version,
+		HostName:   raw.HostName,
+		MountPoint: raw.MountPoint,
+		MountTime:  raw.MountTime,
+		ProcessID:  raw.ProcessID,
+		Stale:      !raw.Expire.IsZero() && raw.Expire.Before(time.Now()),
+	}
+	return nil
+}
+
+// listSessions returns the sessions of the filesystem of a CE volume.
+func listSessions(ctx context.Context, v *jfsVolume) ([]juicefsSession, error) {
+	if !isCommunityEdition(v) {
+		return nil, fmt.Errorf("sessions of Enterprise Edition volumes are managed in the JuiceFS console")
+	}
+	out, err := ceStatus(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Sessions []juicefsSession
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("cannot parse juicefs status of volume %s: %s", dockerName(v), err)
+	}
+	return report.Sessions, nil
+}
+
+// ceStatus runs `juicefs status` for a CE volume and returns its report.
+// The error carries the sanitized log of the client.
+func ceStatus(ctx context.Context, v *jfsVolume) ([]byte, error) {
+	opts, err := mountOptions(v)
+	if err != nil {
+		return nil, err
+	}
+	env, err := optionEnv(opts)
+	if err != nil {
+		return nil, err
+	}
+	status := exec.CommandContext(ctx, ceCliPath, "status", v.Source)
+	status.Env = append(env, "JFS_NO_UPDATE=1")
+	// The report goes to stdout, log messages to stderr.
+	var stdout, stderr bytes.Buffer
+	status.Stdout, status.Stderr = &stdout, &stderr
+	err = status.Run()
+	secrets := volumeSecrets(v, opts)
+	recordOutput(v, status, append(stdout.Bytes(), stderr.Bytes()...), err, secrets)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("juicefs status for volume %s did not finish: %s", dockerName(v), ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("juicefs status failed for volume %s: %s", dockerName(v), sanitizeOutput(string(bytes.TrimSpace(stderr.Bytes())), secrets))
+	}
+	return stdout.Bytes(), nil
+}
+
+// clientSession is the session of the client with PID.
+type clientSession struct {
+	PID int
+	Sid uint64
+}
+
+// clientSessions are the sessions of the clients serving CE volumes on this
+// node by volume. They are kept apart from the client records, which are
+// saved with the state under d's lock, as trackSession runs without it.
+var clientSessions sync.Map
+
+// trackSession records the session of the client serving a CE volume, so that
+// it can be told apart from stale sessions of the same mountpoint. Failures
+// are only logged: the mount works without it. The caller holds v.mu.
+func trackSession(v *jfsVolume) {
+	clientSessions.Delete(dockerName(v))
+	client := v.client.Load()
+	if client == nil || !isCommunityEdition(v) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sessionTimeout)
+	defer cancel()
+	sessions, err := listSessions(ctx, v)
+	if err != nil {
+		volumeLog(mountLog, v).Debugf("cannot record session: %s", err)
+		return
+	}
+	hostname, _ := os.Hostname()
+	for _, s := range sessions {
+		if s.ProcessID == client.PID && s.MountPoint == v.Mountpoint && s.HostName == hostname && !s.Stale {
+			clientSessions.Store(dockerName(v), clientSession{PID: client.PID, Sid: s.Sid})
+			return
+		}
+	}
+	volumeLog(mountLog, v).Debugf("no session found for client %d", client.PID)
+}
+
+// sessionID returns the session of the client serving v, if it was recorded.
+func sessionID(v *jfsVolume) (uint64, bool) {
+	val, ok := clientSessions.Load(dockerName(v))
+	if !ok {
+		return 0, false
+	}
+	s := val.(clientSession)
+	if c := v.client.Load(); c == nil || c.PID != s.PID {
+		return 0, false
+	}
+	return s.Sid, true
+}
+
+// sessionCleanup is the result of cleanupSessions.
+type sessionCleanup struct {
+	Stale     []juicefsSession `json:"stale"`
+	Remaining []juicefsSession `json:"remaining"`
+	Output    string           `json:"output,omitempty"`
+}
+
+// volumeSessions lists the sessions of a volume's filesystem.
+func (d *jfsDriver) volumeSessions(name string) ([]juicefsSession, error) {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	defer cancel()
+	return listSessions(ctx, v)
+}
+
+// cleanupSessions removes the stale sessions of a volume's filesystem with
+// `juicefs gc` and lists the sessions that are still stale afterwards, so
+// that an incomplete cleanup does not go unnoticed. Nothing is run when
+// there are no stale sessions.
+func (d *jfsDriver) cleanupSessions(name string) (*sessionCleanup, error) {
+	v, err := d.lookupVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	defer cancel()
+	sessions, err := listSessions(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	result := &sessionCleanup{Stale: staleSessions(sessions), Remaining: []juicefsSession{}}
+	if len(result.Stale) == 0 {
+		return result, nil
+	}
+	out, err := d.maintainVolume(name, "gc", &maintenanceRequest{})
+	result.Output = string(out)
+	if err != nil {
+		return nil, err
+	}
+	if sessions, err = listSessions(ctx, v); err != nil {
+		return nil, err
+	}
+	result.Remaining = staleSessions(sessions)
+	return result, nil
+}
+
+func staleSessions(sessions []juicefsSession) []juicefsSession {
+	stale := []juicefsSession{}
+	for _, s := range sessions {
+		if s.Stale {
+			stale = append(stale, s)
+		}
+	}
+	return stale
+}
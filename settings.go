@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Driver-wide settings come from the plugin environment (see config.json),
+// so they can be changed with `docker plugin set`.
+
+func envString(name, def string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+	d, err := parseDuration(val)
+	if err != nil {
+		logrus.Warnf("ignoring invalid %s=%q: %s", name, val, err)
+		return def
+	}
+	return d
+}
+
+func envInt(name string, def int) int {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		logrus.Warnf("ignoring invalid %s=%q: %s", name, val, err)
+		return def
+	}
+	return n
+}
+
+func envBool(name string, def bool) bool {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		logrus.Warnf("ignoring invalid %s=%q: %s", name, val, err)
+		return def
+	}
+	return b
+}
+
+// parseDuration accepts Go durations ("90s") as well as plain seconds ("90").
+func parseDuration(val string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(val, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return time.ParseDuration(val)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// listenUnix listens on a unix socket, replacing a stale one, and gives it
+// the group and permission bits.
+func listenUnix(addr string, gid int, mode os.FileMode) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chown(addr, 0, gid); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Chmod(addr, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// socketGroup returns the group owning the plugin socket from the
+// SOCKET_GROUP setting, a group name or numeric gid (default 0, root).
+func socketGroup() (int, error) {
+	val := envString("SOCKET_GROUP", "0")
+	if gid, err := strconv.Atoi(val); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SOCKET_GROUP: %s", err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// socketMode returns the permission bits of the plugin socket from the
+// SOCKET_MODE setting, in octal (default 0660).
+func socketMode() (os.FileMode, error) {
+	return octalSetting("SOCKET_MODE", "0660")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS volumes (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS history (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	time    TEXT NOT NULL,
+	volume  TEXT NOT NULL,
+	type    TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	details TEXT
+);
+CREATE INDEX IF NOT EXISTS history_volume_time ON history (volume, time);
+`
+
+// historyTimeFormat stores times in UTC with a fixed width, so they sort
+// and compare as strings.
+const historyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteBackend keeps the state in a SQLite database. Besides the volumes,
+// which are updated per volume in one transaction like the bolt backend,
+// it records every lifecycle event in a history table that the admin API
+// queries per volume. History older than HISTORY_RETENTION (default 30
+// days) is pruned.
+type sqliteBackend struct {
+	db   *sql.DB
+	path string
+	// saved holds the last saved encoding of each volume.
+	saved  map[string][]byte
+	events chan event
+}
+
+func newSQLiteBackend(root string) (*sqliteBackend, error) {
+	path := filepath.Join(root, "state", "jfs-state.sqlite")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes writers, which SQLite requires anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open %s: %s", path, err)
+	}
+	b := &sqliteBackend{db: db, path: path, saved: map[string][]byte{}, events: make(chan event, 256)}
+	go b.writeEvents()
+	addEventSink(b.recordEvent)
+	return b, nil
+}
+
+func (b *sqliteBackend) String() string {
+	return b.path
+}
+
+// Load reads all volumes. An empty database takes over the volumes of the
+// JSON state file, so switching to the SQLite backend keeps existing
+// volumes.
+func (b *sqliteBackend) Load() (map[string]*jfsVolume, error) {
+	rows, err := b.db.Query("SELECT name, data FROM volumes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	volumes := map[string]*jfsVolume{}
+	for rows.Next() {
+		var name string
+		var data []byte
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, err
+		}
+		v := &jfsVolume{}
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("volume %s: %s", name, err)
+		}
+		volumes[name] = v
+		b.saved[name] = data
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(volumes) > 0 {
+		return volumes, nil
+	}
+
+	file := &fileBackend{path: filepath.Join(filepath.Dir(b.path), "jfs-state.json")}
+	volumes, err = file.Load()
+	if err != nil || volumes == nil {
+		return nil, err
+	}
+	logrus.WithField("statePath", b.path).Infof("importing %d volumes from %s", len(volumes), file)
+	return volumes, b.Save(volumes)
+}
+
+// Save writes the volumes that changed since the last save and deletes the
+// removed ones.
+func (b *sqliteBackend) Save(volumes map[string]*jfsVolume) error {
+	encoded, changed, err := encodeChangedVolumes(b.saved, volumes)
+	if err != nil || !changed {
+		return err
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, data := range encoded {
+		if bytes.Equal(b.saved[name], data) {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO volumes (name, data) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET data = excluded.data", name, string(data)); err != nil {
+			return err
+		}
+	}
+	for name := range b.saved {
+		if _, ok := encoded[name]; !ok {
+			if _, err := tx.Exec("DELETE FROM volumes WHERE name = ?", name); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.saved = encoded
+	return nil
+}
+
+// recordEvent queues an event for writeEvents. Events are dropped rather
+// than delay the operation that emitted them.
+func (b *sqliteBackend) recordEvent(e event) {
+	select {
+	case b.events <- e:
+	default:
+		logrus.WithField("event", e.Type).Warn("state database is busy, event not recorded")
+	}
+}
+
+// writeEvents inserts queued events into the history table and prunes
+// expired history once an hour.
+func (b *sqliteBackend) writeEvents() {
+	var pruned time.Time
+	for e := range b.events {
+		var details []byte
+		if e.Details != nil {
+			details, _ = json.Marshal(e.Details)
+		}
+		_, err := b.db.Exec("INSERT INTO history (time, volume, type, message, details) VALUES (?, ?, ?, ?, ?)",
+			e.Time.UTC().Format(historyTimeFormat), e.Volume, e.Type, e.Message, details)
+		if err != nil {
+			logrus.WithField("event", e.Type).Warnf("cannot record event: %s", err)
+		}
+		if time.Since(pruned) > time.Hour {
+			cutoff := time.Now().Add(-envDuration("HISTORY_RETENTION", 30*24*time.Hour)).UTC()
+			if _, err := b.db.Exec("DELETE FROM history WHERE time < ?", cutoff.Format(historyTimeFormat)); err != nil {
+				logrus.Warnf("cannot prune history: %s", err)
+			}
+			pruned = time.Now()
+		}
+	}
+}
+
+// History returns the events of a volume in [since, until), oldest first.
+func (b *sqliteBackend) History(volume string, since, until time.Time) ([]event, error) {
+	rows, err := b.db.Query("SELECT time, type, message, details FROM history WHERE volume = ? AND time >= ? AND time < ? ORDER BY id",
+		volume, since.UTC().Format(historyTimeFormat), until.UTC().Format(historyTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := []event{}
+	for rows.Next() {
+		var t string
+		var details []byte
+		e := event{Volume: volume}
+		if err := rows.Scan(&t, &e.Type, &e.Message, &details); err != nil {
+			return nil, err
+		}
+		e.Time, _ = time.Parse(historyTimeFormat, t)
+		if len(details) > 0 {
+			json.Unmarshal(details, &e.Details)
+		}
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
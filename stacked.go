@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// stackedMounts are the mount counts of the volumes found stacked by the
+// last check, for the volume status.
+var stackedMounts sync.Map
+
+// mountCounts returns how many JuiceFS mounts are stacked on each
+// mountpoint according to the mount table. More than one means a client
+// was mounted over another, e.g. by a Mount racing crash recovery; only the
+// top one is visible.
+func mountCounts() map[string]int {
+	counts := map[string]int{}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return counts
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// ID parent major:minor root mountpoint options... - fstype source superoptions
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		fields, extra := strings.Fields(pre), strings.Fields(post)
+		if !ok || len(fields) < 5 || len(extra) < 1 || extra[0] != "fuse.juicefs" {
+			continue
+		}
+		counts[unescapeMountinfo(fields[4])]++
+	}
+	return counts
+}
+
+// mountCount returns how many JuiceFS mounts are stacked on mountpoint.
+func mountCount(mountpoint string) int {
+	return mountCounts()[mountpoint]
+}
+
+// collapseStackedMounts is the periodic check for volumes mounted more than
+// once at their mountpoint. The mount table is read once for all volumes.
+func (d *jfsDriver) collapseStackedMounts() error {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		vols[name] = v
+	}
+	d.RUnlock()
+
+	counts := mountCounts()
+	var failed []string
+	for name, v := range vols {
+		if counts[v.Mountpoint] <= 1 {
+			stackedMounts.Delete(name)
+			continue
+		}
+		stackedMounts.Store(name, counts[v.Mountpoint])
+		if err := collapseStackedMount(name, v); err != nil {
+			failed = append(failed, name)
+			continue
+		}
+		stackedMounts.Delete(name)
+	}
+	// Forget removed volumes.
+	stackedMounts.Range(func(k, _ interface{}) bool {
+		if _, ok := vols[k.(string)]; !ok {
+			stackedMounts.Delete(k)
+		}
+		return true
+	})
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to collapse stacked mounts of %v", failed)
+	}
+	return nil
+}
+
+// collapseStackedMount detaches the mounts of a volume below the top one,
+// which keeps serving the volume with its client. The top mount is cloned,
+// all mounts are detached lazily, so containers keep the mount they use
+// until they stop, and the clone is attached in their place.
+func collapseStackedMount(name string, v *jfsVolume) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	n := mountCount(v.Mountpoint)
+	if n <= 1 {
+		return nil
+	}
+	log := monitorLog.WithField("volume", name)
+	log.Warnf("%s is mounted %d times, detaching all but the top mount", v.Mountpoint, n)
+	fail := func(err error) error {
+		log.Error(err)
+		emitEvent("remount_failed", name, err.Error(), nil)
+		return err
+	}
+	top, err := unix.OpenTree(unix.AT_FDCWD, v.Mountpoint, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		return fail(fmt.Errorf("cannot clone the top mount of %s: %s", v.Mountpoint, err))
+	}
+	defer unix.Close(top)
+	for i := 0; i < n; i++ {
+		if err := unix.Unmount(v.Mountpoint, unix.MNT_DETACH); err != nil {
+			return fail(fmt.Errorf("cannot detach %s: %s", v.Mountpoint, err))
+		}
+	}
+	if err := unix.MoveMount(top, "", unix.AT_FDCWD, v.Mountpoint, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		// Nothing is left at the mountpoint; mount the volume afresh.
+		log.Warnf("cannot attach the top mount again, remounting: %s", err)
+		if err := mountVolume(v); err != nil {
+			return fail(fmt.Errorf("failed to remount: %s", err))
+		}
+	}
+	log.Info("collapsed to a single mount")
+	emitEvent("remounted", name, fmt.Sprintf("collapsed %d stacked mounts", n), nil)
+	return nil
+}
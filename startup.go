@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// errStarting is answered by the volume API until startup has finished.
+// Docker reports it and the caller can retry.
+var errStarting = errors.New("[" + codeStarting + "] juicefs volume driver is starting, try again shortly")
+
+// ready is set once startup has finished.
+var ready atomic.Bool
+
+// startup brings the driver in line with the node after the plugin
+// (re)starts: dead mounts left by crashed clients are cleaned up, the CE
+// client version is detected and premount volumes are mounted. The volume
+// API is gated on it.
+func (d *jfsDriver) startup() {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		vols[name] = v
+	}
+	err := d.writeHosts(nil)
+	d.RUnlock()
+	if err != nil {
+		logrus.Warnf("cannot update %s: %s", hostsFile, err)
+	}
+
+	for name, v := range vols {
+		_, err := os.Stat(v.Mountpoint)
+		switch {
+		case errors.Is(err, syscall.ENOTCONN):
+			mountLog.WithField("volume", name).Warnf("cleaning up dead mount %s", v.Mountpoint)
+			if err := lazyUmountVolume(v); err != nil {
+				mountLog.WithField("volume", name).Warn(err)
+			}
+		case err == nil && mountCount(v.Mountpoint) > 1:
+			if err := collapseStackedMount(name, v); err != nil {
+				mountLog.WithField("volume", name).Warn(err)
+			}
+		case err == nil && isJuiceFSMountedRoot(v.Mountpoint):
+			mountLog.WithField("volume", name).Infof("still mounted from the previous run for %d containers", len(v.MountIDs))
+			v.mu.Lock()
+			startAccessLog(v)
+			v.mu.Unlock()
+			go func() {
+				v.mu.Lock()
+				defer v.mu.Unlock()
+				trackSession(v)
+			}()
+		}
+		d.adoptMounts(v)
+	}
+
+	if version, err := ceVersion(); err != nil {
+		logrus.Warnf("cannot detect juicefs version: %s", err)
+	} else {
+		logrus.Infof("juicefs CE client %s", version)
+	}
+
+	d.premountAll()
+}
+
+// adoptMounts counts the containers of a volume still mounted from the
+// previous run, or the run of the other instance of an active-standby pair,
+// so that the mount is not torn down under them. The mounts of a volume
+// that is not mounted anymore are forgotten; Docker mounts it again when
+// those containers start.
+func (d *jfsDriver) adoptMounts(v *jfsVolume) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d.Lock()
+	defer d.Unlock()
+	if isJuiceFSMountedRoot(v.Mountpoint) {
+		v.connections.Store(int32(len(v.MountIDs)))
+	} else {
+		v.MountIDs = nil
+	}
+}
+
+// startupGate answers the volume API with errStarting until the driver is
+// ready.
+type startupGate struct {
+	d *jfsDriver
+}
+
+func (g startupGate) Create(r *volume.CreateRequest) error {
+	if !ready.Load() {
+		return errStarting
+	}
+	return traceRequest(func(ctx context.Context) error {
+		return apiError(codeCreateFailed, g.d.Create(ctx, r))
+	})
+}
+
+func (g startupGate) List() (*volume.ListResponse, error) {
+	if !ready.Load() {
+		return &volume.ListResponse{}, errStarting
+	}
+	var resp *volume.ListResponse
+	err := traceRequest(func(context.Context) (err error) {
+		resp, err = g.d.List()
+		return apiError(codeInternal, err)
+	})
+	return resp, err
+}
+
+func (g startupGate) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
+	if !ready.Load() {
+		return &volume.GetResponse{}, errStarting
+	}
+	var resp *volume.GetResponse
+	err := traceRequest(func(context.Context) (err error) {
+		resp, err = g.d.Get(r)
+		return apiError(codeInternal, err)
+	})
+	return resp, err
+}
+
+func (g startupGate) Remove(r *volume.RemoveRequest) error {
+	if !ready.Load() {
+		return errStarting
+	}
+	return traceRequest(func(ctx context.Context) error {
+		return apiError(codeRemoveFailed, g.d.Remove(ctx, r))
+	})
+}
+
+func (g startupGate) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
+	if !ready.Load() {
+		return &volume.PathResponse{}, errStarting
+	}
+	var resp *volume.PathResponse
+	err := traceRequest(func(context.Context) (err error) {
+		resp, err = g.d.Path(r)
+		return apiError(codeInternal, err)
+	})
+	return resp, err
+}
+
+func (g startupGate) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
+	if !ready.Load() {
+		return &volume.MountResponse{}, errStarting
+	}
+	var resp *volume.MountResponse
+	err := traceRequest(func(ctx context.Context) (err error) {
+		resp, err = g.d.Mount(ctx, r)
+		return apiError(codeMountFailed, err)
+	})
+	return resp, err
+}
+
+func (g startupGate) Unmount(r *volume.UnmountRequest) error {
+	if !ready.Load() {
+		return errStarting
+	}
+	return traceRequest(func(ctx context.Context) error {
+		return apiError(codeUnmountFailed, g.d.Unmount(ctx, r))
+	})
+}
+
+func (g startupGate) Capabilities() *volume.CapabilitiesResponse {
+	return g.d.Capabilities()
+}
+
+// readyz handles
+//
+//	GET /readyz
+//
+// with 200 once startup has finished and 503 before.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		writeAdminJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "starting"})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// sdNotify sends a state such as "READY=1" to systemd when the plugin runs
+// under a service manager that set NOTIFY_SOCKET.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		logrus.Warnf("sd_notify: %s", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logrus.Warnf("sd_notify: %s", err)
+	}
+}
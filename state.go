@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stateFile is the persisted driver state. The checksum covers the raw
+// volume map, so partial writes and corruption are detected at load time;
+// the generation grows with every save, so a state file rewritten behind
+// the driver's back is noticed.
+type stateFile struct {
+	Generation uint64          `json:"generation"`
+	Checksum   string          `json:"checksum"`
+	Volumes    json.RawMessage `json:"volumes"`
+}
+
+func stateChecksum(volumes []byte) string {
+	sum := sha256.Sum256(volumes)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// decodeState parses a state file and verifies its checksum. Files written
+// before checksums were introduced hold the bare volume map.
+func decodeState(data []byte) (map[string]*jfsVolume, uint64, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, 0, err
+	}
+	_, hasChecksum := probe["checksum"]
+	_, hasGeneration := probe["generation"]
+
+	volumes := map[string]*jfsVolume{}
+	if !hasChecksum || !hasGeneration {
+		if err := json.Unmarshal(data, &volumes); err != nil {
+			return nil, 0, err
+		}
+		return volumes, 0, nil
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, 0, err
+	}
+	if sum := stateChecksum(sf.Volumes); sum != sf.Checksum {
+		return nil, 0, fmt.Errorf("checksum mismatch: recorded %s, computed %s", sf.Checksum, sum)
+	}
+	if err := json.Unmarshal(sf.Volumes, &volumes); err != nil {
+		return nil, 0, err
+	}
+	return volumes, sf.Generation, nil
+}
+
+// stateBackend persists the volume map of the driver.
+type stateBackend interface {
+	// Load returns the saved volumes, or nil when nothing has been saved
+	// yet.
+	Load() (map[string]*jfsVolume, error)
+	// Save replaces the saved volumes.
+	Save(volumes map[string]*jfsVolume) error
+	// String describes where the state is kept, for logs.
+	String() string
+}
+
+// newStateBackend returns the backend selected with STATE_BACKEND: "file"
+// (the default) keeps the state in the plugin's state directory, "bolt" and
+// "sqlite" in a database there and "juicefs" in a JuiceFS filesystem.
+func newStateBackend(root string) (stateBackend, error) {
+	switch backend := envString("STATE_BACKEND", "file"); backend {
+	case "file":
+		return &fileBackend{path: filepath.Join(root, "state", "jfs-state.json")}, nil
+	case "bolt":
+		return newBoltBackend(root)
+	case "sqlite":
+		return newSQLiteBackend(root)
+	case "juicefs":
+		return newJuiceFSBackend(root)
+	default:
+		return nil, fmt.Errorf("unknown STATE_BACKEND %q", backend)
+	}
+}
+
+// historyBackend is implemented by state backends that record the
+// lifecycle events of volumes.
+type historyBackend interface {
+	// History returns the events of a volume in [since, until), oldest
+	// first.
+	History(volume string, since, until time.Time) ([]event, error)
+}
+
+// encodeChangedVolumes returns the JSON encoding of each volume and whether
+// any differs from saved, the encodings last written, for backends that
+// store volumes individually.
+func encodeChangedVolumes(saved map[string][]byte, volumes map[string]*jfsVolume) (map[string][]byte, bool, error) {
+	encoded := make(map[string][]byte, len(volumes))
+	changed := len(volumes) != len(saved)
+	for name, v := range volumes {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, false, err
+		}
+		encoded[name] = data
+		changed = changed || !bytes.Equal(saved[name], data)
+	}
+	return encoded, changed, nil
+}
+
+// loadState reads the volume map from the state backend.
+func (d *jfsDriver) loadState() error {
+	volumes, err := d.state.Load()
+	if err != nil {
+		return err
+	}
+	if volumes != nil {
+		d.volumes = volumes
+	}
+	loadEventHistory(d.volumes)
+	loadLastErrors(d.volumes)
+	loadQuarantines(d.volumes)
+	loadClients(d.volumes)
+	loadFormatted(d.volumes)
+	return nil
+}
+
+// writeState saves the volume map to the state backend.
+func (d *jfsDriver) writeState() error {
+	storeEventHistory(d.volumes)
+	storeLastErrors(d.volumes)
+	storeQuarantines(d.volumes)
+	storeClients(d.volumes)
+	storeFormatted(d.volumes)
+	return d.state.Save(d.volumes)
+}
+
+// fileBackend keeps the state in a JSON file.
+type fileBackend struct {
+	path string
+	// generation is the generation of the state file last read or written.
+	generation uint64
+}
+
+func (b *fileBackend) String() string {
+	return b.path
+}
+
+// Load reads the state file, falling back to the backup written by the
+// previous save if the file is missing or corrupt. It fails rather than
+// start with a wrong volume map when neither can be read.
+func (b *fileBackend) Load() (map[string]*jfsVolume, error) {
+	backup := b.path + ".bak"
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		if _, bakErr := os.Stat(backup); os.IsNotExist(bakErr) {
+			logrus.WithField("statePath", b.path).Debug("no state found")
+			return nil, nil
+		}
+	}
+	if err == nil {
+		var volumes map[string]*jfsVolume
+		if volumes, b.generation, err = decodeState(data); err == nil {
+			return volumes, nil
+		}
+	}
+	logrus.WithField("statePath", b.path).Errorf("state is unreadable, trying the backup: %s", err)
+
+	data, bakErr := os.ReadFile(backup)
+	if bakErr == nil {
+		var volumes map[string]*jfsVolume
+		if volumes, b.generation, bakErr = decodeState(data); bakErr == nil {
+			logrus.WithField("statePath", backup).Warnf("restored state generation %d from backup", b.generation)
+			return volumes, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot load state from %s (%s) or %s (%s)", b.path, err, backup, bakErr)
+}
+
+// Save writes the volume map with a new generation and checksum. The
+// previous file is kept as a backup, linked or copied so that the state file
+// itself stays in place, and the new one is renamed over it, so a crash
+// never leaves a missing or partially written state file.
+func (b *fileBackend) Save(volumes map[string]*jfsVolume) error {
+	if data, err := os.ReadFile(b.path); err == nil {
+		if _, gen, err := decodeState(data); err == nil && gen != b.generation {
+			logrus.WithField("statePath", b.path).Warnf("state generation %d on disk does not match %d, another writer may be active", gen, b.generation)
+		}
+	}
+
+	raw, err := json.Marshal(volumes)
+	if err != nil {
+		return err
+	}
+	sf := stateFile{Generation: b.generation + 1, Checksum: stateChecksum(raw), Volumes: raw}
+	data, err := json.Marshal(sf)
+	if err != nil {
+		return err
+	}
+
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := backupFile(b.path, b.path+".bak"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(b.path)); err != nil {
+		return err
+	}
+	b.generation = sf.Generation
+	return nil
+}
+
+// backupFile replaces backup with a hard link to path, or a copy where the
+// filesystem has no hard links.
+func backupFile(path, backup string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, backup); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(backup, data, 0600)
+}
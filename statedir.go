@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// prepareStateDir creates the state directory if it is missing, with
+// STATE_DIR_MODE (default 0700) and, if set, STATE_DIR_OWNER ("uid:gid"),
+// and verifies that files can be written and synced in it. An existing
+// directory is left as it is: for a managed plugin it is the host's
+// /var/lib/docker/plugins.
+func prepareStateDir(dir string) error {
+	mode, err := octalSetting("STATE_DIR_MODE", "0700")
+	if err != nil {
+		return err
+	}
+	uid, gid, err := stateDirOwner()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, mode); err != nil {
+			return fmt.Errorf("cannot create state directory %s: %s", dir, err)
+		}
+		// MkdirAll applies the umask.
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+		if uid >= 0 {
+			if err := os.Chown(dir, uid, gid); err != nil {
+				return fmt.Errorf("cannot set the owner of state directory %s: %s", dir, err)
+			}
+		}
+		logrus.Infof("created state directory %s", dir)
+	}
+
+	probe := filepath.Join(dir, fmt.Sprintf(".jfs-write-test-%d", os.Getpid()))
+	f, err := os.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		_, err = f.Write([]byte("ok\n"))
+		if serr := f.Sync(); err == nil {
+			err = serr
+		}
+		f.Close()
+		os.Remove(probe)
+	}
+	if err != nil {
+		return fmt.Errorf("state directory %s is not writable, volumes could not be saved: %s; check the plugin's state mount", dir, err)
+	}
+	return syncDir(dir)
+}
+
+// stateDirOwner parses STATE_DIR_OWNER. uid is -1 when it is not set.
+func stateDirOwner() (uid, gid int, err error) {
+	val := os.Getenv("STATE_DIR_OWNER")
+	if val == "" {
+		return -1, -1, nil
+	}
+	u, g, ok := strings.Cut(val, ":")
+	if uid, err = strconv.Atoi(u); err == nil && ok {
+		gid, err = strconv.Atoi(g)
+	}
+	if err != nil || !ok || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("invalid STATE_DIR_OWNER %q: expected uid:gid such as 0:0", val)
+	}
+	return uid, gid, nil
+}
+
+// octalSetting parses a setting holding octal permission bits.
+func octalSetting(name, def string) (os.FileMode, error) {
+	val := envString(name, def)
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid %s %q: expected octal permission bits such as %s", name, val, def)
+	}
+	return os.FileMode(mode), nil
+}
+
+// syncDir fsyncs a directory, making renames and new files in it durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stateFSDir is the directory of a state filesystem reserved for the plugin.
+const stateFSDir = ".docker-volume-juicefs"
+
+// newJuiceFSBackend keeps the state file in a reserved directory of the
+// JuiceFS filesystem given by STATE_METAURL, mounted privately inside the
+// plugin. The state then lives with the volumes' own meta engine rather
+// than on the host, so a reprovisioned host with the same STATE_NAMESPACE
+// (default: its hostname) finds its volume definitions again.
+func newJuiceFSBackend(root string) (stateBackend, error) {
+	metaurl, err := resolveSecret(os.Getenv("STATE_METAURL"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve STATE_METAURL: %s", err)
+	}
+	if metaurl == "" {
+		return nil, fmt.Errorf("STATE_BACKEND=juicefs requires STATE_METAURL")
+	}
+	metaurl = normalizeMetaURL(metaurl)
+	namespace := os.Getenv("STATE_NAMESPACE")
+	if namespace == "" {
+		if namespace, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+
+	mountpoint := filepath.Join(root, "statefs")
+	if err := mountStateFS(metaurl, mountpoint); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(mountpoint, stateFSDir, namespace)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	logrus.WithField("statePath", dir).Infof("keeping state in %s", redactMetaURL(metaurl))
+	return &fileBackend{path: filepath.Join(dir, "jfs-state.json")}, nil
+}
+
+// mountStateFS mounts the state filesystem unless it is still mounted from
+// a previous run, and waits for the mount to appear.
+func mountStateFS(metaurl, mountpoint string) error {
+	if isJuiceFSMountedRoot(mountpoint) {
+		return nil
+	}
+	if err := os.MkdirAll(mountpoint, 0700); err != nil {
+		return err
+	}
+	mount := exec.Command(ceCliPath, "mount", "-d", "--no-usage-report", metaurl, mountpoint)
+	mount.Env = append(os.Environ(), "JFS_NO_UPDATE=1")
+	if err := mount.Start(); err != nil {
+		return fmt.Errorf("cannot mount state filesystem: %s", err)
+	}
+	go mount.Wait()
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(time.Second) {
+		if isJuiceFSMountedRoot(mountpoint) {
+			return nil
+		}
+	}
+	return fmt.Errorf("state filesystem %s did not mount at %s", redactMetaURL(metaurl), mountpoint)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdClient emits metrics over StatsD UDP, with DogStatsD tags, for
+// setups that collect metrics with Datadog or Telegraf rather than
+// Prometheus. Sends are fire and forget.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+// statsd is nil unless STATSD_ADDRESS is set; its methods do nothing then.
+var statsd *statsdClient
+
+// setupStatsd connects to STATSD_ADDRESS (host:port). Metric names start
+// with STATSD_PREFIX (default "jfs.") and carry the STATSD_TAGS, a comma
+// separated list of key:value tags, besides their own.
+func setupStatsd(d *jfsDriver) error {
+	addr := envString("STATSD_ADDRESS", "")
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("invalid STATSD_ADDRESS %q: %s", addr, err)
+	}
+	statsd = &statsdClient{conn: conn, prefix: envString("STATSD_PREFIX", "jfs.")}
+	if tags := envString("STATSD_TAGS", ""); tags != "" {
+		statsd.tags = strings.Split(tags, ",")
+	}
+	addEventSink(func(e event) {
+		statsd.count("events", "type:"+e.Type, "volume:"+e.Volume)
+	})
+	schedule("statsd", envDuration("STATSD_INTERVAL", 10*time.Second), func() error {
+		d.RLock()
+		defer d.RUnlock()
+		mounted := 0
+		for name, v := range d.volumes {
+			statsd.gauge("volume.connections", float64(v.connectionCount()), "volume:"+name)
+			if isJuiceFSMountedRoot(v.Mountpoint) {
+				mounted++
+			}
+		}
+		statsd.gauge("volumes.defined", float64(len(d.volumes)))
+		statsd.gauge("volumes.mounted", float64(mounted))
+		return nil
+	})
+	return nil
+}
+
+func (c *statsdClient) send(name, value, typ string, tags []string) {
+	if c == nil {
+		return
+	}
+	line := c.prefix + name + ":" + value + "|" + typ
+	if all := append(append([]string{}, c.tags...), tags...); len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	c.conn.Write([]byte(line))
+}
+
+func (c *statsdClient) count(name string, tags ...string) {
+	c.send(name, "1", "c", tags)
+}
+
+func (c *statsdClient) gauge(name string, value float64, tags ...string) {
+	c.send(name, fmt.Sprint(value), "g", tags)
+}
+
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond)), "ms", tags)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// materializeDir holds files such as Ceph keyrings that the juicefs CLI
+// reads from disk. It is inside the plugin rootfs rather than the state
+// directory, so secrets are not persisted across plugin upgrades; the files
+// are written again before every format and mount.
+const materializeDir = "/run/jfs/files"
+
+// storageSecrets are the storage options holding secrets, besides the
+// credentials that can be rotated.
+var storageSecrets = map[string]bool{
+	"ceph-keyring":    true,
+	"azure-key":       true,
+	"azure-sas":       true,
+	"gcs-credentials": true,
+}
+
+// defaultAzureEndpointSuffix is the endpoint suffix of the Azure public
+// cloud; sovereign clouds use e.g. core.chinacloudapi.cn.
+const defaultAzureEndpointSuffix = "core.windows.net"
+
+// storageEnv returns the environment the juicefs CLI needs for the storage
+// options of a volume.
+func storageEnv(opts map[string]string) ([]string, error) {
+	// Credentials are exported as well as passed as flags, as some
+	// clients only read them from the environment.
+	env := credentialEnv(opts)
+	if conf := opts["ceph-conf"]; conf != "" {
+		path, err := materializeFile("ceph.conf", conf)
+		if err != nil {
+			return nil, fmt.Errorf("ceph-conf: %s", err)
+		}
+		env = append(env, "CEPH_CONF="+path)
+	}
+	if keyring := opts["ceph-keyring"]; keyring != "" {
+		path, err := materializeFile("ceph.keyring", keyring)
+		if err != nil {
+			return nil, fmt.Errorf("ceph-keyring: %s", err)
+		}
+		env = append(env, "CEPH_ARGS=--keyring="+path)
+	}
+	if conn := azureConnectionString(opts); conn != "" {
+		env = append(env, "AZURE_STORAGE_CONNECTION_STRING="+conn)
+	}
+	if creds, err := gcsCredentials(opts); err != nil {
+		return nil, err
+	} else if creds != "" {
+		path, err := materializeFile("gcs.json", creds)
+		if err != nil {
+			return nil, fmt.Errorf("gcs-credentials: %s", err)
+		}
+		env = append(env, "GOOGLE_APPLICATION_CREDENTIALS="+path)
+	}
+	return env, nil
+}
+
+// gcsCredentials returns the service account key of a GCS volume, read
+// from the gcs-credentials-file path inside the plugin or given with
+// gcs-credentials (base64 encoded JSON or a secret reference).
+func gcsCredentials(opts map[string]string) (string, error) {
+	if path := opts["gcs-credentials-file"]; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("gcs-credentials-file: %w", err)
+		}
+		return string(data), nil
+	}
+	return opts["gcs-credentials"], nil
+}
+
+// checkGCSOptions validates that a GCS service account key is a JSON
+// document, given for gs storage only.
+func checkGCSOptions(opts map[string]string) error {
+	_, hasFile := opts["gcs-credentials-file"]
+	inline, hasInline := opts["gcs-credentials"]
+	if !hasFile && !hasInline {
+		return nil
+	}
+	if opts["storage"] != "gs" {
+		return fmt.Errorf("options gcs-credentials-file and gcs-credentials require storage=gs")
+	}
+	if hasFile && hasInline {
+		return fmt.Errorf("options gcs-credentials-file and gcs-credentials cannot be combined")
+	}
+	if hasInline && isSecretRef(inline) {
+		// Resolved at mount time.
+		return nil
+	}
+	creds, err := gcsCredentials(opts)
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(creds)); err == nil {
+		creds = string(decoded)
+	}
+	var key struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(creds), &key); err != nil || key.Type == "" {
+		return fmt.Errorf("GCS credentials are not a service account key JSON document")
+	}
+	return nil
+}
+
+// azureConnectionString builds the connection string the client reads for
+// Azure Blob storage from the azure-account option and either azure-key or
+// azure-sas.
+func azureConnectionString(opts map[string]string) string {
+	account := opts["azure-account"]
+	if account == "" {
+		return ""
+	}
+	suffix := opts["azure-endpoint-suffix"]
+	if suffix == "" {
+		suffix = defaultAzureEndpointSuffix
+	}
+	if sas := opts["azure-sas"]; sas != "" {
+		return fmt.Sprintf("BlobEndpoint=https://%s.blob.%s;SharedAccessSignature=%s", account, suffix, strings.TrimPrefix(sas, "?"))
+	}
+	return fmt.Sprintf("DefaultEndpointsProtocol=https;AccountName=%s;AccountKey=%s;EndpointSuffix=%s", account, opts["azure-key"], suffix)
+}
+
+// isAzureStorage reports whether storage is Azure Blob storage; `azure` is
+// accepted as an alias of the client's `wasb`.
+func isAzureStorage(storage string) bool {
+	return storage == "wasb" || storage == "azure"
+}
+
+// checkAzureOptions validates the azure-* options: they only apply to
+// Azure Blob storage and need an account with exactly one of a key or a
+// SAS token.
+func checkAzureOptions(opts map[string]string) error {
+	var given []string
+	for _, k := range []string{"azure-account", "azure-key", "azure-sas", "azure-endpoint-suffix"} {
+		if _, ok := opts[k]; ok {
+			given = append(given, k)
+		}
+	}
+	if len(given) == 0 {
+		return nil
+	}
+	if !isAzureStorage(opts["storage"]) {
+		return fmt.Errorf("options %s require storage=wasb", strings.Join(given, ", "))
+	}
+	if opts["azure-account"] == "" {
+		return fmt.Errorf("option azure-account is required with %s", strings.Join(given, ", "))
+	}
+	if (opts["azure-key"] == "") == (opts["azure-sas"] == "") {
+		return fmt.Errorf("exactly one of azure-key and azure-sas must be given with azure-account")
+	}
+	if _, ok := opts["access-key"]; ok {
+		return fmt.Errorf("options access-key and secret-key cannot be combined with azure-account")
+	}
+	if suffix := opts["azure-endpoint-suffix"]; strings.Contains(suffix, "/") || strings.HasPrefix(suffix, ".") {
+		return fmt.Errorf("invalid azure-endpoint-suffix %q: expected a domain such as core.chinacloudapi.cn", suffix)
+	}
+	return nil
+}
+
+// materializeFile writes the contents of a file option, given base64
+// encoded or as is (e.g. resolved from a secret reference), to a file only
+// root can read and returns its path. Files are named after their contents,
+// so volumes sharing a file share the copy.
+func materializeFile(name, val string) (string, error) {
+	data := []byte(val)
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val)); err == nil {
+		data = decoded
+	}
+	sum := sha256.Sum256(data)
+	path := filepath.Join(materializeDir, hex.EncodeToString(sum[:8])+"-"+name)
+	if err := os.MkdirAll(materializeDir, 0700); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// checkCephOptions validates `storage=ceph` volumes: the bucket is
+// ceph://POOL, access-key is the cluster name and secret-key the client
+// user, and the ceph-conf and ceph-keyring files only apply to them.
+func checkCephOptions(opts map[string]string) error {
+	_, hasConf := opts["ceph-conf"]
+	_, hasKeyring := opts["ceph-keyring"]
+	if opts["storage"] != "ceph" {
+		if hasConf || hasKeyring {
+			return fmt.Errorf("options ceph-conf and ceph-keyring require storage=ceph")
+		}
+		return nil
+	}
+	pool, ok := strings.CutPrefix(opts["bucket"], "ceph://")
+	if !ok || pool == "" || strings.Contains(pool, "/") {
+		return fmt.Errorf("invalid bucket %q: ceph storage expects ceph://POOL", opts["bucket"])
+	}
+	if hasKeyring && opts["secret-key"] == "" {
+		return fmt.Errorf("option ceph-keyring requires secret-key to name the client user, e.g. client.admin")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// storageEnvVars maps storage types to the environment variables the juicefs
+// clients read their credentials from, keyed by option. The "" entry applies
+// to every storage type; the variables of the volume's storage type are
+// exported in addition. Further storage types are configured with `storage_env` in the
+// configuration file.
+var storageEnvVars = struct {
+	sync.RWMutex
+	table map[string]map[string]string
+}{table: map[string]map[string]string{
+	"": {
+		"access-key":    "ACCESS_KEY",
+		"secret-key":    "SECRET_KEY",
+		"access-key2":   "ACCESS_KEY2",
+		"secret-key2":   "SECRET_KEY2",
+		"session-token": "SESSION_TOKEN",
+	},
+	"s3": {
+		"access-key":    "AWS_ACCESS_KEY_ID",
+		"secret-key":    "AWS_SECRET_ACCESS_KEY",
+		"session-token": "AWS_SESSION_TOKEN",
+	},
+	"oss": {
+		"access-key":    "ALICLOUD_ACCESS_KEY_ID",
+		"secret-key":    "ALICLOUD_ACCESS_KEY_SECRET",
+		"session-token": "SECURITY_TOKEN",
+	},
+	"cos": {
+		"access-key": "COS_SECRETID",
+		"secret-key": "COS_SECRETKEY",
+	},
+	"obs": {
+		"access-key": "HWCLOUD_ACCESS_KEY",
+		"secret-key": "HWCLOUD_SECRET_KEY",
+	},
+	"b2": {
+		"access-key": "B2_ACCOUNT_ID",
+		"secret-key": "B2_APP_KEY",
+	},
+	"swift": {
+		"access-key": "OS_USERNAME",
+		"secret-key": "OS_PASSWORD",
+	},
+}}
+
+// addStorageEnv merges the `storage_env` table of the configuration file
+// into the built-in one. An empty variable name stops an option from being
+// exported.
+func addStorageEnv(table map[string]map[string]string) {
+	storageEnvVars.Lock()
+	defer storageEnvVars.Unlock()
+	for storage, vars := range table {
+		if storageEnvVars.table[storage] == nil {
+			storageEnvVars.table[storage] = map[string]string{}
+		}
+		for k, name := range vars {
+			storageEnvVars.table[storage][canonicalize(k)] = name
+		}
+	}
+}
+
+// credentialEnv returns the KEY=VALUE pairs exporting the credential
+// options of a volume for its storage type.
+func credentialEnv(opts map[string]string) []string {
+	canonical := map[string]string{}
+	for k, val := range opts {
+		canonical[canonicalize(k)] = val
+	}
+
+	// Both the generic and the storage specific variable are exported, as
+	// the clients read either. An empty name stops the option from being
+	// exported at all.
+	storageEnvVars.RLock()
+	vars := map[string][]string{}
+	for _, storage := range []string{"", canonical["storage"]} {
+		for k, name := range storageEnvVars.table[storage] {
+			if name == "" {
+				vars[k] = nil
+				continue
+			}
+			if _, ok := vars[k]; (ok && vars[k] == nil) || slices.Contains(vars[k], name) {
+				continue
+			}
+			vars[k] = append(vars[k], name)
+		}
+	}
+	storageEnvVars.RUnlock()
+
+	var env []string
+	for _, k := range sortedKeys(vars) {
+		if val := canonical[k]; val != "" {
+			for _, name := range vars[k] {
+				env = append(env, name+"="+val)
+			}
+		}
+	}
+	return env
+}
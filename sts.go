@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultCredentialRefresh is how often session credentials given as secret
+// references are fetched again and pushed to JuiceFS. STS credentials live
+// for an hour by default, so this leaves several attempts before expiry.
+const defaultCredentialRefresh = 15 * time.Minute
+
+// credentialRefresh returns the volume's refresh interval from the
+// `credential-refresh` option or CREDENTIAL_REFRESH. Only volumes whose
+// session-token is a secret reference are refreshed; a literal token cannot
+// change.
+func credentialRefresh(v *jfsVolume) time.Duration {
+	if !isSecretRef(v.Options["session-token"]) {
+		return 0
+	}
+	if val, ok := v.Options["credential-refresh"]; ok {
+		d, err := parseDuration(val)
+		if err != nil {
+			mountLog.WithField("volume", v.Name).Warnf("ignoring credential-refresh %q: %s", val, err)
+		} else {
+			return d
+		}
+	}
+	return envDuration("CREDENTIAL_REFRESH", defaultCredentialRefresh)
+}
+
+// refreshSessionCredentials is the periodic job that keeps the temporary
+// credentials of mounted volumes fresh. The references are resolved again,
+// bypassing the secret cache, and the new access key, secret key and session
+// token are pushed together with `juicefs config` (CE) or `juicefs auth`
+// (EE), which running clients pick up without a remount. Unmounted volumes
+// resolve fresh credentials when they are next mounted.
+func (d *jfsDriver) refreshSessionCredentials() error {
+	d.RLock()
+	due := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		interval := credentialRefresh(v)
+		if interval > 0 && v.connectionCount() > 0 && time.Since(v.credentialsRefreshed) >= interval {
+			due[name] = v
+		}
+	}
+	d.RUnlock()
+
+	var failed int
+	for _, name := range sortedKeys(due) {
+		v := due[name]
+		r := &credentialRotation{Credentials: map[string]string{}}
+		for _, k := range []string{"access-key", "secret-key", "session-token"} {
+			val := optionValue(v.Options, k)
+			if val == "" {
+				continue
+			}
+			forgetSecret(val)
+			r.Credentials[k] = val
+		}
+		if err := d.rotateCredentials(name, r); err != nil {
+			failed++
+			continue
+		}
+		d.Lock()
+		v.credentialsRefreshed = time.Now()
+		d.Unlock()
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to refresh credentials of %d volume(s)", failed)
+	}
+	return nil
+}
+
+// forgetSecret drops a cached secret reference so it is fetched again.
+func forgetSecret(val string) {
+	r, ref, ok := parseSecretRef(val)
+	if !ok {
+		return
+	}
+	if c, ok := r.(*cachingResolver); ok {
+		c.forget(ref)
+	}
+}
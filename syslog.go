@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogHook forwards log entries to syslog or journald, mapping logrus
+// levels to syslog priorities.
+type syslogHook struct {
+	w *syslog.Writer
+}
+
+// setupSyslog forwards the logs of every subsystem to SYSLOG_ADDRESS, a
+// unix:// socket path such as the journal's
+// unix:///jfs/run/systemd/journal/dev-log, or a udp:// or tcp://
+// host:port. Entries are tagged with SYSLOG_IDENT.
+func setupSyslog() error {
+	addr := envString("SYSLOG_ADDRESS", "")
+	if addr == "" {
+		return nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid SYSLOG_ADDRESS %q: %s", addr, err)
+	}
+	var network, raddr string
+	switch u.Scheme {
+	case "unix", "unixgram":
+		network, raddr = "unixgram", u.Path
+	case "udp", "tcp":
+		network, raddr = u.Scheme, u.Host
+	default:
+		return fmt.Errorf("invalid SYSLOG_ADDRESS %q: expected unix://, udp:// or tcp://", addr)
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, envString("SYSLOG_IDENT", "docker-volume-juicefs"))
+	if err != nil {
+		return fmt.Errorf("cannot connect to syslog at %s: %s", addr, err)
+	}
+	hook := &syslogHook{w: w}
+	for _, l := range allLoggers() {
+		l.AddHook(hook)
+	}
+	// The juicefs clients log to /dev/log themselves unless no-syslog is
+	// set, which lets their logs reach the same socket.
+	if network == "unixgram" {
+		if _, err := os.Lstat("/dev/log"); os.IsNotExist(err) {
+			if err := os.Symlink(raddr, "/dev/log"); err != nil {
+				logrus.Warnf("client logs are not forwarded to syslog: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *syslogHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *syslogHook) Fire(e *logrus.Entry) error {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, e.Data[k])
+	}
+	line := b.String()
+	switch e.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.w.Crit(line)
+	case logrus.ErrorLevel:
+		return h.w.Err(line)
+	case logrus.WarnLevel:
+		return h.w.Warning(line)
+	case logrus.InfoLevel:
+		return h.w.Info(line)
+	default:
+		return h.w.Debug(line)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateVars are available to Go templates in option values, e.g.
+// `cache-dir=/cache/{{.Hostname}}/{{.Volume}}`.
+type templateVars struct {
+	// Name is the JuiceFS filesystem name (the `name` option).
+	Name string
+	// Volume is the Docker volume name.
+	Volume   string
+	Hostname string
+	// NodeID identifies the Docker host: NODE_ID from the plugin settings,
+	// else the machine ID, else the hostname.
+	NodeID string
+}
+
+func newTemplateVars(v *jfsVolume) templateVars {
+	hostname, _ := os.Hostname()
+	nodeID := os.Getenv("NODE_ID")
+	if nodeID == "" {
+		if data, err := os.ReadFile("/etc/machine-id"); err == nil {
+			nodeID = strings.TrimSpace(string(data))
+		}
+	}
+	if nodeID == "" {
+		nodeID = hostname
+	}
+	return templateVars{
+		Name:     v.Name,
+		Volume:   dockerName(v),
+		Hostname: hostname,
+		NodeID:   nodeID,
+	}
+}
+
+// expandOptions returns a copy of the volume options with templates in the
+// values evaluated. The stored options keep the raw templates so that
+// host-specific variables are evaluated on the host that mounts the volume.
+func expandOptions(v *jfsVolume) (map[string]string, error) {
+	vars := newTemplateVars(v)
+	opts := make(map[string]string, len(v.Options))
+	for k, val := range v.Options {
+		if !strings.Contains(val, "{{") {
+			opts[k] = val
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(val)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, vars); err != nil {
+			return nil, err
+		}
+		opts[k] = buf.String()
+	}
+	return opts, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import "strings"
+
+// tenantConfig describes a tenant sharing the plugin with others. Its
+// volumes must be named with its prefix, get its default options (which
+// is also where its credential sources, such as an `env-file`, go) and are
+// limited in number.
+type tenantConfig struct {
+	Prefix     string            `json:"prefix"`
+	Defaults   map[string]string `json:"defaults"`
+	MaxVolumes int               `json:"max_volumes"`
+}
+
+// tenantOf returns the tenant a new volume belongs to: the `tenant` option
+// (or the `label.tenant` label) if given, otherwise the tenant whose prefix
+// the volume name starts with.
+func (d *jfsDriver) tenantOf(name string, options map[string]string) string {
+	if t := options["tenant"]; t != "" {
+		return t
+	}
+	if t := options[labelPrefix+"tenant"]; t != "" {
+		return t
+	}
+	tenant, prefix := "", ""
+	for t, tc := range d.config.Tenants {
+		if tc.Prefix != "" && strings.HasPrefix(name, tc.Prefix) && len(tc.Prefix) > len(prefix) {
+			tenant, prefix = t, tc.Prefix
+		}
+	}
+	return tenant
+}
+
+// applyTenant checks a new volume against its tenant and merges the
+// tenant's defaults underneath its options. It records the tenant in the
+// `tenant` option. The caller holds d's lock.
+func (d *jfsDriver) applyTenant(name string, options map[string]string) (map[string]string, error) {
+	t := d.tenantOf(name, options)
+	if t == "" {
+		if d.config.RequireTenant {
+			return nil, logError("volume %s does not belong to a tenant", name)
+		}
+		return options, nil
+	}
+	tc, ok := d.config.Tenants[t]
+	if !ok {
+		return nil, logError("unknown tenant %q", t)
+	}
+	if !strings.HasPrefix(name, tc.Prefix) {
+		return nil, logError("volumes of tenant %s must be named %s...", t, tc.Prefix)
+	}
+	if err := d.checkTenantQuota(t); err != nil {
+		return nil, err
+	}
+
+	merged := map[string]string{}
+	for k, val := range tc.Defaults {
+		merged[k] = val
+	}
+	for k, val := range options {
+		merged[k] = val
+	}
+	merged["tenant"] = t
+	return merged, nil
+}
+
+// checkTenantQuota fails if tenant t already has as many volumes as it may.
+// The caller holds d's lock.
+func (d *jfsDriver) checkTenantQuota(t string) error {
+	tc, ok := d.config.Tenants[t]
+	if !ok || tc.MaxVolumes <= 0 {
+		return nil
+	}
+	n := 0
+	for _, v := range d.volumes {
+		if v.Options["tenant"] == t {
+			n++
+		}
+	}
+	if n >= tc.MaxVolumes {
+		return logError("tenant %s already has %d of at most %d volumes", t, n, tc.MaxVolumes)
+	}
+	return nil
+}
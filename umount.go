@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// errUmountUnsupported is returned by juicefsUmount when the bundled client
+// has no umount subcommand.
+var errUmountUnsupported = errors.New("juicefs umount is not supported")
+
+// umountUnsupported remembers the clients without a umount subcommand.
+var umountUnsupported sync.Map
+
+// umountForceUnsupported remembers the clients whose umount subcommand has
+// no --force flag.
+var umountForceUnsupported sync.Map
+
+// juicefsUmount unmounts with `juicefs umount [--force]`, which checks
+// whether the mount is busy and cleans up the client, unlike a plain
+// umount. It returns errUmountUnsupported if the client of the volume's
+// edition lacks the subcommand.
+func juicefsUmount(v *jfsVolume, force bool) (*exec.Cmd, error) {
+	cli := eeCliPath
+	if isCommunityEdition(v) {
+		cli = ceCliPath
+	}
+	if _, ok := umountUnsupported.Load(cli); ok {
+		return nil, errUmountUnsupported
+	}
+
+	if _, ok := umountForceUnsupported.Load(cli); ok {
+		force = false
+	}
+	cmd := exec.Command(cli, "umount")
+	if force {
+		cmd.Args = append(cmd.Args, "--force")
+	}
+	cmd.Args = append(cmd.Args, v.Mountpoint)
+	volumeLog(cliLog, v).Debug(cmd)
+	out, err := cmd.CombinedOutput()
+	recordOutput(v, cmd, out, err, nil)
+	if err == nil {
+		return cmd, nil
+	}
+	if force && isUnknownFlag(out) {
+		cliLog.Infof("%s umount has no --force flag, unmounting without it", cli)
+		umountForceUnsupported.Store(cli, true)
+		return juicefsUmount(v, false)
+	}
+	if isUnknownCommand(out) {
+		cliLog.Infof("%s has no umount subcommand, using umount", cli)
+		umountUnsupported.Store(cli, true)
+		return nil, errUmountUnsupported
+	}
+	return cmd, fmt.Errorf("juicefs umount failed: %s", bytes.TrimSpace(out))
+}
+
+// isUnknownCommand recognizes the usage errors of old CE (urfave/cli) and
+// EE (argparse) clients for a subcommand they do not have.
+func isUnknownCommand(out []byte) bool {
+	msg := strings.ToLower(string(out))
+	for _, fragment := range []string{"no help topic", "command not found", "unknown command", "invalid choice"} {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnknownFlag recognizes the usage errors of CE (urfave/cli) and EE
+// (argparse) clients for a flag they do not have.
+func isUnknownFlag(out []byte) bool {
+	msg := strings.ToLower(string(out))
+	return strings.Contains(msg, "flag provided but not defined") || strings.Contains(msg, "unrecognized arguments")
+}
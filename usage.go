@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Default alert thresholds, overridable with CAPACITY_ALERT/INODE_ALERT and
+// per volume with capacity-alert/inode-alert.
+const (
+	defaultCapacityAlert = "90%"
+	defaultInodeAlert    = "90%"
+)
+
+// volumeUsage holds capacity figures of a mounted volume.
+type volumeUsage struct {
+	TotalBytes     uint64
+	UsedBytes      uint64
+	AvailableBytes uint64
+	// TotalInodes is the inode limit of the filesystem.
+	TotalInodes uint64
+	UsedInodes  uint64
+	FreeInodes  uint64
+}
+
+func statUsage(mountpoint string) (*volumeUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountpoint, &st); err != nil {
+		return nil, err
+	}
+	bsize := uint64(st.Bsize)
+	return &volumeUsage{
+		TotalBytes:     st.Blocks * bsize,
+		UsedBytes:      (st.Blocks - st.Bfree) * bsize,
+		AvailableBytes: st.Bavail * bsize,
+		TotalInodes:    st.Files,
+		UsedInodes:     st.Files - st.Ffree,
+		FreeInodes:     st.Ffree,
+	}, nil
+}
+
+// usageThreshold is either a percentage of the total that may be used, or
+// an absolute amount that must stay free. The zero value never fires.
+type usageThreshold struct {
+	percent float64
+	free    uint64
+}
+
+// parseThreshold parses "90%" or an absolute amount. Absolute capacity
+// amounts accept binary size suffixes ("10G", "512MiB").
+func parseThreshold(val string, size bool) (usageThreshold, error) {
+	val = strings.TrimSpace(val)
+	if val == "" || val == "0" {
+		return usageThreshold{}, nil
+	}
+	if p, ok := strings.CutSuffix(val, "%"); ok {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f > 100 {
+			return usageThreshold{}, fmt.Errorf("invalid threshold %q: percentage must be in (0, 100]", val)
+		}
+		return usageThreshold{percent: f}, nil
+	}
+	if !size {
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return usageThreshold{}, fmt.Errorf("invalid threshold %q: expected a percentage or a number of inodes", val)
+		}
+		return usageThreshold{free: n}, nil
+	}
+	n, err := parseSize(val)
+	if err != nil {
+		return usageThreshold{}, fmt.Errorf("invalid threshold %q: %s", val, err)
+	}
+	return usageThreshold{free: n}, nil
+}
+
+// parseSize parses a byte count with an optional binary suffix.
+func parseSize(val string) (uint64, error) {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(val), "B"), "I")
+	shift := 0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		case 'T':
+			shift = 40
+		case 'P':
+			shift = 50
+		}
+		if shift > 0 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size such as 10G")
+	}
+	return uint64(n * float64(uint64(1)<<shift)), nil
+}
+
+func (t usageThreshold) exceeded(used, free, total uint64) bool {
+	if t.percent > 0 {
+		return total > 0 && float64(used)*100/float64(total) >= t.percent
+	}
+	return t.free > 0 && free < t.free
+}
+
+func (t usageThreshold) String() string {
+	if t.percent > 0 {
+		return fmt.Sprintf("%g%% used", t.percent)
+	}
+	return fmt.Sprintf("less than %d free", t.free)
+}
+
+// volumeThreshold returns the volume's threshold for kind ("capacity" or
+// "inode") from the `<kind>-alert` option or the driver default.
+func volumeThreshold(v *jfsVolume, kind string) usageThreshold {
+	val, ok := v.Options[kind+"-alert"]
+	if !ok {
+		def := defaultCapacityAlert
+		if kind == "inode" {
+			def = defaultInodeAlert
+		}
+		val = def
+		if env := envString(strings.ToUpper(kind)+"_ALERT", ""); env != "" {
+			val = env
+		}
+	}
+	t, err := parseThreshold(val, kind == "capacity")
+	if err != nil {
+		monitorLog.WithField("volume", v.Name).Warnf("ignoring %s alert: %s", kind, err)
+	}
+	return t
+}
+
+// volumeStatus returns the Status map reported by Get for a volume.
+func volumeStatus(v *jfsVolume) map[string]interface{} {
+	status := map[string]interface{}{
+		"mounted": isJuiceFSMountedRoot(v.Mountpoint),
+	}
+	addConfig(status, v)
+	if job := v.format.Load(); job != nil {
+		job.addStatus(status)
+	}
+	if deprecated := deprecatedOptions(v); len(deprecated) > 0 {
+		status["deprecated_options"] = deprecated
+	}
+	addOrphanStatus(status, dockerName(v))
+	if lastError := v.lastError.Load(); lastError != nil {
+		status["last_error"] = lastError
+	}
+	if q := v.quarantine.Load(); q != nil {
+		status["quarantined_until"] = q.Until
+		status["quarantine_reason"] = q.Reason
+	}
+	if status["mounted"] == true {
+		if sid, ok := sessionID(v); ok {
+			status["session_id"] = sid
+		}
+		if isCommunityEdition(v) {
+			if t, ok := lastMetaBackup(v); ok {
+				status["last_meta_backup"] = t.UTC()
+			}
+		}
+		addUsage(status, v)
+	}
+	if n, ok := stackedMounts.Load(dockerName(v)); ok {
+		status["stacked_mounts"] = n
+	}
+	return status
+}
+
+// addConfig adds how the volume is configured, without any secrets.
+func addConfig(status map[string]interface{}, v *jfsVolume) {
+	status["edition"] = edition(v)
+	status["read_only"] = isReadOnly(v)
+	if isCommunityEdition(v) {
+		status["metaurl"] = redactMetaURL(v.Source)
+		// JuiceFS formats volumes without a storage option on local disk.
+		status["storage"] = "file"
+	} else {
+		status["volume"] = v.Name
+	}
+	if storage := v.Options["storage"]; storage != "" {
+		status["storage"] = storage
+	}
+	if subdir := v.Options["subdir"]; subdir != "" {
+		status["subdir"] = subdir
+	}
+	if tenant := v.Options["tenant"]; tenant != "" {
+		status["tenant"] = tenant
+	}
+}
+
+// addUsage adds the filesystem capacity as seen through the mountpoint.
+func addUsage(status map[string]interface{}, v *jfsVolume) {
+	u, err := statUsage(v.Mountpoint)
+	if err != nil {
+		status["usage_error"] = err.Error()
+		return
+	}
+	status["total_bytes"] = u.TotalBytes
+	status["used_bytes"] = u.UsedBytes
+	status["available_bytes"] = u.AvailableBytes
+	status["inodes_total"] = u.TotalInodes
+	status["inodes_used"] = u.UsedInodes
+	status["inodes_free"] = u.FreeInodes
+}
+
+// checkUsage is the periodic usage monitor. When a mounted volume's capacity
+// or inode usage crosses its threshold it logs a warning, counts an alert
+// metric and fires a webhook event; it does so again once usage recovers,
+// rather than on every run.
+func (d *jfsDriver) checkUsage() error {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		vols[name] = v
+	}
+	d.RUnlock()
+
+	for name, v := range vols {
+		if !isJuiceFSMountedRoot(v.Mountpoint) {
+			continue
+		}
+		u, err := statUsage(v.Mountpoint)
+		if err != nil {
+			monitorLog.WithField("volume", name).Warnf("statfs failed: %s", err)
+			continue
+		}
+		d.checkThreshold(name, v, "capacity", u.UsedBytes, u.AvailableBytes, u.TotalBytes)
+		d.checkThreshold(name, v, "inode", u.UsedInodes, u.FreeInodes, u.TotalInodes)
+	}
+	return nil
+}
+
+func (d *jfsDriver) checkThreshold(name string, v *jfsVolume, kind string, used, free, total uint64) {
+	t := volumeThreshold(v, kind)
+	over := t.exceeded(used, free, total)
+
+	d.Lock()
+	if v.alerts == nil {
+		v.alerts = map[string]bool{}
+	}
+	active := v.alerts[kind]
+	v.alerts[kind] = over
+	d.Unlock()
+
+	log := monitorLog.WithField("volume", name)
+	details := map[string]interface{}{"kind": kind, "used": used, "free": free, "total": total, "threshold": t.String()}
+	switch {
+	case over && !active:
+		log.Warnf("%s usage crossed threshold (%s): %d used, %d free of %d", kind, t, used, free, total)
+		usageAlertsTotal.WithLabelValues(name, kind).Inc()
+		statsd.count("usage_alerts", "volume:"+name, "kind:"+kind)
+		usageAlertActive.WithLabelValues(name, kind).Set(1)
+		emitEvent("usage_alert", name, fmt.Sprintf("%s usage crossed threshold (%s)", kind, t), details)
+	case !over && active:
+		log.Infof("%s usage back within threshold (%s)", kind, t)
+		usageAlertActive.WithLabelValues(name, kind).Set(0)
+		emitEvent("usage_recovered", name, fmt.Sprintf("%s usage back within threshold (%s)", kind, t), details)
+	}
+}
+
+// forgetUsageAlerts drops the alert series of a removed volume.
+func forgetUsageAlerts(name string) {
+	for _, kind := range []string{"capacity", "inode"} {
+		usageAlertsTotal.DeleteLabelValues(name, kind)
+		usageAlertActive.DeleteLabelValues(name, kind)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// cliVersion is the major/minor/patch triple reported by `juicefs version`.
+type cliVersion [3]int
+
+func parseCLIVersion(s string) (cliVersion, error) {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return cliVersion{}, fmt.Errorf("no version found in %q", s)
+	}
+	var v cliVersion
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, nil
+}
+
+func (v cliVersion) less(o cliVersion) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+func (v cliVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+var (
+	ceVersionOnce sync.Once
+	ceVersionVal  cliVersion
+	ceVersionErr  error
+)
+
+// ceVersion returns the version of the bundled CE client. It is detected
+// once per plugin process since the binary is part of the plugin rootfs.
+func ceVersion() (cliVersion, error) {
+	ceVersionOnce.Do(func() {
+		out, err := exec.Command(ceCliPath, "version").CombinedOutput()
+		if err != nil {
+			ceVersionErr = fmt.Errorf("juicefs version: %v: %s", err, out)
+			return
+		}
+		ceVersionVal, ceVersionErr = parseCLIVersion(string(out))
+		if ceVersionErr == nil {
+			cliLog.Debugf("detected juicefs CE client %s", ceVersionVal)
+		}
+	})
+	return ceVersionVal, ceVersionErr
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// repairDeadMounts is the periodic watchdog for the most common JuiceFS
+// failure: the client died and its mountpoint answers every access with
+// ENOTCONN ("Transport endpoint is not connected"). The dead mount is
+// detached lazily and the volume mounted again, so containers using it see
+// the filesystem come back.
+func (d *jfsDriver) repairDeadMounts() error {
+	d.RLock()
+	vols := map[string]*jfsVolume{}
+	for name, v := range d.volumes {
+		if v.connectionCount() > 0 {
+			vols[name] = v
+		}
+	}
+	d.RUnlock()
+
+	var failed []string
+	for name, v := range vols {
+		if err := repairDeadMount(name, v); err != nil {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to repair dead mounts of %v", failed)
+	}
+	return nil
+}
+
+func repairDeadMount(name string, v *jfsVolume) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.connectionCount() == 0 {
+		return nil
+	}
+	if _, err := os.Stat(v.Mountpoint); !errors.Is(err, syscall.ENOTCONN) {
+		return nil
+	}
+
+	log := monitorLog.WithField("volume", name)
+	log.Warnf("%s is not connected, remounting", v.Mountpoint)
+	if err := lazyUmountVolume(v); err != nil {
+		emitEvent("remount_failed", name, err.Error(), nil)
+		return err
+	}
+	if err := mountVolume(v); err != nil {
+		log.Errorf("failed to remount: %s", err)
+		emitEvent("remount_failed", name, err.Error(), nil)
+		return err
+	}
+	log.Info("remounted")
+	emitEvent("remounted", name, "transport endpoint was not connected", nil)
+	return nil
+}